@@ -1,6 +1,7 @@
 module github.com/piranha/gostatic
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/alecthomas/chroma/v2 v2.5.0
 	github.com/bmatcuk/doublestar/v4 v4.6.0
 	github.com/dlclark/regexp2 v1.8.1 // indirect
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	flags "github.com/jessevdk/go-flags"
@@ -23,6 +24,8 @@ const (
 	ExitCodeInvalidFlags = 1
 	// ExitCodeInvalidConfig is used when an invalid configuration file is given.
 	ExitCodeInvalidConfig = 2
+	// ExitCodeBuildFailed is used when there were errors building pages.
+	ExitCodeBuildFailed = 3
 	// ExitCodeOther is used in all other situations.
 	ExitCodeOther = 127
 )
@@ -32,11 +35,23 @@ type Opts struct {
 	ShowProcessors bool    `long:"processors" description:"show page processors"`
 	ShowConfig     bool    `long:"show-config" description:"print config as JSON"`
 	ShowSummary    bool    `long:"summary" description:"print all pages on stdout"`
+	ShowRules      bool    `long:"show-rules" description:"print which rule is used for each source file"`
 	InitExample    *string `short:"i" long:"init" description:"create example site"`
 	DumpPage       string  `short:"d" long:"dump" description:"print page metadata as JSON (pass path to source or target file)"`
+	ExplainPage    string  `short:"e" long:"explain" description:"print how page is built and why it is rendered (pass path to source or target file)"`
+	Graph          string  `long:"graph" optional:"yes" optional-value:"dot" choice:"dot" choice:"json" description:"print graph of pages and their dependencies in Graphviz (dot) or JSON format"`
 
 	// checked in Page.Changed()
-	Force bool `short:"f" long:"force" description:"force building all pages"`
+	Force     bool   `short:"f" long:"force" description:"force building all pages"`
+	Jobs      int    `short:"j" long:"jobs" description:"number of pages to process in parallel (default: number of CPUs)"`
+	KeepGoing bool   `short:"k" long:"keep-going" description:"keep building after errors and report all of them at the end"`
+	UseHashes bool   `short:"H" long:"hash" description:"detect changes by content hashes (kept in output directory) instead of modification times"`
+	NoPrune   bool   `long:"no-prune" description:"do not remove files left in output by previous builds, which are not produced anymore"`
+	ListStale bool   `long:"list-stale" description:"print files which would be removed from output as stale and exit"`
+	DryRun    string `long:"dry-run" optional:"yes" optional-value:"text" choice:"text" choice:"json" description:"print which files would be written, copied or deleted and why, without building (text or json)"`
+
+	Profile string   `long:"profile" env:"GOSTATIC_PROFILE" description:"apply [profile NAME] section of config"`
+	Defines []string `short:"D" long:"define" value-name:"KEY=VALUE" description:"override or add config constant (can be repeated)"`
 
 	Watch       bool   `short:"w" long:"watch" description:"serve site on HTTP, rebuild on changes and hot reload HTML in browser"`
 	NoHotreload bool   `long:"no-hotreload" description:"disable hot reload during --watch"`
@@ -104,17 +119,23 @@ func main() {
 		return
 	}
 
-	// config, err := gostatic.NewSiteConfig(args[0])
-	// if err != nil {
-	// 	errhandle(fmt.Errorf("invalid config file '%s': %v", args[0], err))
-	// 	os.Exit(ExitCodeInvalidConfig)
-	// }
-
 	site := gostatic.NewSite(args[0], processors.DefaultProcessors)
 
 	if opts.Force {
 		site.ForceRefresh = true
 	}
+	site.Jobs = opts.Jobs
+	site.KeepGoing = opts.KeepGoing
+	site.NoPrune = opts.NoPrune
+	site.UseHashes = opts.UseHashes
+	site.Profile = opts.Profile
+	site.Defines = opts.Defines
+
+	err = site.Reconfig()
+	if err != nil {
+		errhandle(fmt.Errorf("invalid config file '%s': %v", args[0], err))
+		os.Exit(ExitCodeInvalidConfig)
+	}
 
 	if opts.ShowConfig {
 		x, err := json.MarshalIndent(site.SiteConfig, "", "  ")
@@ -123,6 +144,31 @@ func main() {
 		return
 	}
 
+	if opts.ShowRules {
+		sources := make([]string, 0, len(site.Pages))
+		for _, page := range site.Pages {
+			sources = append(sources, page.Source)
+		}
+		sort.Strings(sources)
+
+		for _, source := range sources {
+			matches := site.Rules.Matches(source)
+			if len(matches) == 0 {
+				out("%s: no rule\n", source)
+				continue
+			}
+			out("%s: %s\n", source, matches[0].Pattern)
+			for i, m := range matches[1:] {
+				if i == 0 && gostatic.Ambiguous(matches) {
+					out("  overrides %s (equally specific, defined later)\n", m.Pattern)
+				} else {
+					out("  overrides %s\n", m.Pattern)
+				}
+			}
+		}
+		return
+	}
+
 	if len(opts.DumpPage) > 0 {
 		page := site.PageBySomePath(opts.DumpPage)
 		if page == nil {
@@ -136,17 +182,88 @@ func main() {
 		return
 	}
 
+	if len(opts.ExplainPage) > 0 {
+		page := site.PageBySomePath(opts.ExplainPage)
+		if page == nil {
+			out("Page '%s' not found (supply source or destination path)\n",
+				opts.ExplainPage)
+			return
+		}
+		site.Explain(os.Stdout, page)
+		return
+	}
+
+	if len(opts.Graph) > 0 {
+		graph := site.Graph()
+		if opts.Graph == "json" {
+			err = graph.WriteJSON(os.Stdout)
+		} else {
+			err = graph.WriteDot(os.Stdout)
+		}
+		errhandle(err)
+		return
+	}
+
+	if len(opts.DryRun) > 0 {
+		plan, err := site.Plan()
+		if err != nil {
+			errhandle(err)
+			os.Exit(ExitCodeOther)
+		}
+		if opts.DryRun == "json" {
+			x, err := json.MarshalIndent(plan, "", "  ")
+			errhandle(err)
+			out("%s\n", x)
+		} else {
+			rendered := 0
+			for _, entry := range plan {
+				out("%-6s %s (%s)\n", entry.Action, entry.Path, entry.Reason)
+				if entry.Action != gostatic.ActionDelete {
+					rendered++
+				}
+			}
+			out("%d of %d pages would be rendered\n", rendered, len(site.Pages))
+		}
+		if len(site.Errors) > 0 {
+			errhandle(site.Errors)
+			os.Exit(ExitCodeBuildFailed)
+		}
+		return
+	}
+
+	if opts.ListStale {
+		stale, err := site.StaleOutputs()
+		errhandle(err)
+		for _, path := range stale {
+			out("%s\n", path)
+		}
+		return
+	}
+
 	if opts.ShowSummary {
-		site.Summary()
+		err = site.Summary()
 	} else {
-		site.Render()
+		err = site.Render()
+	}
+
+	if err != nil {
+		errhandle(err)
+		if !opts.Watch {
+			os.Exit(ExitCodeBuildFailed)
+		}
 	}
 
 	if opts.Watch {
-		err := hotreload.Watch([]string{site.SiteConfig.Source}, site.SiteConfig.Templates,
+		files := append(site.SiteConfig.Files(), site.SiteConfig.Templates...)
+		err := hotreload.Watch([]string{site.SiteConfig.Source}, files,
+			site.Skipped,
 			func() {
-				site.Reconfig()
-				site.Render()
+				err := site.Reconfig()
+				if err != nil {
+					errhandle(fmt.Errorf("invalid config file '%s': %v", args[0], err))
+					return
+				}
+				errhandle(site.Render())
 			})
 		errhandle(err)
 
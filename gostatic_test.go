@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// runGostatic runs test binary as gostatic with args in dir (current one if
+// empty), see TestMain
+func runGostatic(t *testing.T, dir string, args ...string) (string, int) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOSTATIC_TEST_MAIN=1")
+	output, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return string(output), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), ExitCodeOk
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("GOSTATIC_TEST_MAIN") == "1" {
+		main()
+		os.Exit(ExitCodeOk)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExitCodeBuildFailed(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config":   "TEMPLATES = t.tmpl\nSOURCE = src\nOUTPUT = out\n\n*.md:\n\t:gostatic-missing-command\n",
+		"t.tmpl":   `{{ define "page" }}{{ .Content }}{{ end }}`,
+		"src/a.md": "a",
+		"src/b.md": "b",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(dir, "config")
+
+	output, code := runGostatic(t, "", "-j", "1", config)
+	if code != ExitCodeBuildFailed {
+		t.Errorf("expected exit code %d, got %d: %s", ExitCodeBuildFailed, code, output)
+	}
+	if strings.Count(output, "not found") != 1 {
+		t.Errorf("expected build to stop at first error, got %s", output)
+	}
+
+	output, code = runGostatic(t, "", "-k", config)
+	if code != ExitCodeBuildFailed {
+		t.Errorf("expected exit code %d, got %d: %s", ExitCodeBuildFailed, code, output)
+	}
+	if !strings.Contains(output, "build failed with 2 errors") {
+		t.Errorf("expected errors of all pages with -k, got %s", output)
+	}
+
+	if _, code = runGostatic(t, "", filepath.Join(dir, "missing")); code != ExitCodeInvalidConfig {
+		t.Errorf("expected exit code %d for missing config, got %d",
+			ExitCodeInvalidConfig, code)
+	}
+}
+
+// copyDir copies directory tree from src to dst
+func copyDir(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests output of --explain, --graph and --dry-run on testdata/site against
+// golden files, run `go test -update' after intended changes of formats.
+func TestOutputFormats(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, filepath.Join("testdata", "site"), dir)
+
+	if output, code := runGostatic(t, dir, "config"); code != ExitCodeOk {
+		t.Fatalf("build failed: %s", output)
+	}
+	// a source changed after build and a file left from older build
+	future := time.Now().Add(time.Hour)
+	err := os.Chtimes(filepath.Join(dir, "src", "blog", "one.md"), future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "out", "old.html"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := os.OpenFile(filepath.Join(dir, "out", ".gostatic-manifest"),
+		os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.WriteString("old.html\n")
+	manifest.Close()
+
+	var testTable = []struct {
+		golden string
+		args   []string
+	}{
+		{"explain.golden", []string{"-e", "blog/index.md", "config"}},
+		{"graph-dot.golden", []string{"--graph", "config"}},
+		{"graph-json.golden", []string{"--graph=json", "config"}},
+		{"dry-run.golden", []string{"--dry-run", "config"}},
+		{"dry-run-json.golden", []string{"--dry-run=json", "config"}},
+	}
+	for _, s := range testTable {
+		output, code := runGostatic(t, dir, s.args...)
+		if code != ExitCodeOk {
+			t.Errorf("%s: exit code %d: %s", s.golden, code, output)
+			continue
+		}
+
+		path := filepath.Join("testdata", s.golden)
+		if *update {
+			if err := ioutil.WriteFile(path, []byte(output), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, []byte(output)) {
+			t.Errorf("%s: output differs, expected:\n%s\ngot:\n%s",
+				s.golden, expected, output)
+		}
+	}
+}
@@ -183,7 +183,7 @@ func ServeHTTP(source, port string, hotreload bool) error {
 	// }
 
 	// http.HandleFunc(EndpointPath, server.Upgrade)
-	filemods, err := fileWatcher([]string{source}, []string{})
+	filemods, err := fileWatcher([]string{source}, []string{}, nil)
 	if err != nil {
 		return err
 	}
@@ -230,9 +230,10 @@ func ServeHTTP(source, port string, hotreload bool) error {
 	return http.ListenAndServe(":"+port, nil)
 }
 
-// Watch starts file watcher
-func Watch(dirs, files []string, callback func()) error {
-	filemods, err := fileWatcher(dirs, files)
+// Watch starts file watcher, changes of files for which skip returns true
+// (dotfiles if skip is nil) are not reported
+func Watch(dirs, files []string, skip func(string) bool, callback func()) error {
+	filemods, err := fileWatcher(dirs, files, skip)
 	if err != nil {
 		return err
 	}
@@ -12,18 +12,33 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
-func watchAll(watcher *fsnotify.Watcher) filepath.WalkFunc {
+// isDotfile is default filter of files, which are not watched
+func isDotfile(fn string) bool {
+	return strings.HasPrefix(filepath.Base(fn), ".")
+}
+
+func watchAll(watcher *fsnotify.Watcher, skip func(string) bool) filepath.WalkFunc {
 	return func(fn string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
+		if skip(fn) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		watcher.Add(fn)
 		return nil
 	}
 }
 
-func fileWatcher(dirs, files []string) (chan string, error) {
+func fileWatcher(dirs, files []string, skip func(string) bool) (chan string, error) {
+	if skip == nil {
+		skip = isDotfile
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -35,7 +50,7 @@ func fileWatcher(dirs, files []string) (chan string, error) {
 		for {
 			select {
 			case ev := <-watcher.Events:
-				if strings.HasPrefix(filepath.Base(ev.Name), ".") {
+				if skip(ev.Name) {
 					continue
 				}
 
@@ -53,7 +68,7 @@ func fileWatcher(dirs, files []string) (chan string, error) {
 	}()
 
 	for _, path := range dirs {
-		filepath.Walk(path, watchAll(watcher))
+		filepath.Walk(path, watchAll(watcher, skip))
 	}
 	for _, path := range files {
 		watcher.Add(path)
@@ -0,0 +1,297 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DatabaseName is the name of a file in the output directory, which keeps
+// hashes of everything pages were built from, used when site.UseHashes is set
+const DatabaseName = ".gostatic-db"
+
+// BuildRecord describes what a page was built from.
+type BuildRecord struct {
+	Source string
+	// Hash of source content, empty for virtual pages
+	Hash string `json:",omitempty"`
+	// Size and ModTime of source, so that it's not rehashed when unchanged
+	Size    int64 `json:",omitempty"`
+	ModTime time.Time
+	// fingerprints of a rule and of config
+	Rule string
+	Site string
+	// template files page uses and their hashes
+	Templates map[string]string `json:",omitempty"`
+	// hash of page config defaults
+	Defaults string `json:",omitempty"`
+	// paths of dependencies
+	Deps []string `json:",omitempty"`
+}
+
+// BuildDB is a persistent database of BuildRecords, keyed by page path.
+type BuildDB struct {
+	Pages map[string]*BuildRecord
+
+	path string
+	// records computed during this build, not committed yet, and pages
+	// which were written to output
+	fresh   map[string]*BuildRecord
+	written map[string]bool
+	mx      sync.Mutex
+}
+
+func OpenBuildDB(path string) (*BuildDB, error) {
+	db := &BuildDB{
+		Pages:   make(map[string]*BuildRecord),
+		path:    path,
+		fresh:   make(map[string]*BuildRecord),
+		written: make(map[string]bool),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, db)
+	if err != nil {
+		return nil, fmt.Errorf("broken build database '%s': %v", path, err)
+	}
+	if db.Pages == nil {
+		db.Pages = make(map[string]*BuildRecord)
+	}
+	return db, nil
+}
+
+// Save writes records of pages, which were written or are up to date. Changed
+// pages, which were not written (i.e. build stopped at an error), keep their
+// previous records, and failed ones are dropped.
+func (db *BuildDB) Save(site *Site) error {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	pages := make(map[string]*BuildRecord, len(site.Pages))
+	for _, page := range site.Pages {
+		rec := db.fresh[page.Path]
+		switch {
+		case site.Failed(page):
+		case rec != nil && (db.written[page.Path] || page.state == StateUnchanged):
+			pages[page.Path] = rec
+		case db.Pages[page.Path] != nil:
+			pages[page.Path] = db.Pages[page.Path]
+		}
+	}
+	db.Pages = pages
+
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(db.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := db.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.path)
+}
+
+// markWritten marks page as written to output, so that its record is saved
+func (db *BuildDB) markWritten(page *Page) error {
+	db.mx.Lock()
+	rec, prev := db.fresh[page.Path], db.Pages[page.Path]
+	db.mx.Unlock()
+
+	// no record if state of page was not checked (i.e. all pages are forced
+	// to be rendered)
+	if rec == nil {
+		var err error
+		rec, err = db.record(page, prev)
+		if err != nil {
+			return err
+		}
+	}
+
+	db.mx.Lock()
+	db.fresh[page.Path] = rec
+	db.written[page.Path] = true
+	db.mx.Unlock()
+	return nil
+}
+
+// record computes current BuildRecord of a page
+func (db *BuildDB) record(page *Page, prev *BuildRecord) (*BuildRecord, error) {
+	rec := &BuildRecord{
+		Source: page.Source,
+		Rule:   page.ruleFingerprint(),
+		Site:   page.Site.fingerprint,
+		Deps:   make([]string, 0, len(page.Deps)),
+	}
+	for _, dep := range page.Deps {
+		rec.Deps = append(rec.Deps, dep.Path)
+	}
+	sort.Strings(rec.Deps)
+
+	if page.Rule != nil && page.fromSource {
+		if d, err := page.pageDefaults(); err == nil {
+			rec.Defaults = d.hash
+		}
+	}
+
+	if files := page.TemplateFiles(); len(files) > 0 {
+		rec.Templates = make(map[string]string, len(files))
+		for _, fn := range files {
+			rec.Templates[fn] = page.Site.templateHashes[fn]
+		}
+	}
+
+	stat, err := os.Stat(page.FullPath())
+	if os.IsNotExist(err) {
+		// virtual page
+		return rec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec.Size = stat.Size()
+	rec.ModTime = stat.ModTime()
+	if prev != nil && prev.Hash != "" && prev.Size == rec.Size &&
+		prev.ModTime.Equal(rec.ModTime) {
+		rec.Hash = prev.Hash
+		return rec, nil
+	}
+
+	rec.Hash, err = hashFiles(page.FullPath())
+	return rec, err
+}
+
+// changeReason tells why page has to be rebuilt, or returns empty string
+func (db *BuildDB) changeReason(page *Page) string {
+	db.mx.Lock()
+	prev := db.Pages[page.Path]
+	db.mx.Unlock()
+
+	rec, err := db.record(page, prev)
+	if err != nil {
+		return fmt.Sprintf("cannot read source: %v", err)
+	}
+
+	db.mx.Lock()
+	db.fresh[page.Path] = rec
+	db.mx.Unlock()
+
+	if _, err := os.Stat(page.OutputPath()); err != nil {
+		return "output does not exist"
+	}
+
+	switch {
+	case prev == nil:
+		return "page is not in build database"
+	case prev.Site != rec.Site:
+		return "config changed"
+	case prev.Rule != rec.Rule:
+		return "rule changed"
+	case prev.Hash != rec.Hash:
+		return "source content changed"
+	case prev.Defaults != rec.Defaults:
+		return "defaults changed"
+	case strings.Join(prev.Deps, "\n") != strings.Join(rec.Deps, "\n"):
+		return "list of dependencies changed"
+	case len(prev.Templates) != len(rec.Templates):
+		return "list of templates changed"
+	}
+	for fn, hash := range rec.Templates {
+		if prev.Templates[fn] != hash {
+			return "template " + fn + " changed"
+		}
+	}
+	return ""
+}
+
+// ruleFingerprint is a hash of everything in a rule which affects output
+func (page *Page) ruleFingerprint() string {
+	if page.Rule == nil {
+		return ""
+	}
+	parts := []string{page.Pattern, strings.Join(page.Rule.Deps, " ")}
+	for _, cmd := range page.Rule.Commands {
+		parts = append(parts, string(cmd))
+	}
+	return hashString(strings.Join(parts, "\n"))
+}
+
+// configFingerprint is a hash of config after constants (including ones from
+// environment), includes and profile were applied, and of environment
+// variables templates are allowed to read (see EnvFunc)
+func configFingerprint(config *SiteConfig) (string, error) {
+	env := make(map[string]string)
+	allowed := strings.Fields(config.Other["Env_allow"])
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		for _, pattern := range allowed {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				env[name] = os.Getenv(name)
+				break
+			}
+		}
+	}
+
+	data, err := json.Marshal(struct {
+		Source    string
+		Output    string
+		Templates []string
+		Rules     RuleMap
+		Other     map[string]string
+		Env       map[string]string
+	}{config.Source, config.Output, config.Templates, config.Rules,
+		config.Other, env})
+	if err != nil {
+		return "", err
+	}
+	return hashString(string(data)), nil
+}
+
+func hashString(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// hashFiles returns hash of contents of files, missing files are hashed as
+// empty ones
+func hashFiles(paths ...string) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err == nil {
+			_, err = io.Copy(h, file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,180 @@
+package gostatic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestBuildDBSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenBuildDB(filepath.Join(dir, DatabaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := &Site{db: db, failed: make(map[*Page]bool)}
+	for _, name := range []string{"written", "unchanged", "skipped", "failed", "new"} {
+		page := &Page{Site: site, Path: name, state: StateChanged}
+		site.Pages = append(site.Pages, page)
+		if name != "new" {
+			db.Pages[name] = &BuildRecord{Source: name, Hash: "old"}
+		}
+		db.fresh[name] = &BuildRecord{Source: name, Hash: "new"}
+	}
+	db.written["written"] = true
+	db.written["failed"] = true
+	site.Pages[1].state = StateUnchanged
+	site.failed[site.Pages[3]] = true
+
+	if err := db.Save(site); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := OpenBuildDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"written": "new", "unchanged": "new", "skipped": "old"}
+	if len(saved.Pages) != len(expected) {
+		t.Errorf("expected %d records, got %d", len(expected), len(saved.Pages))
+	}
+	for name, hash := range expected {
+		if rec := saved.Pages[name]; rec == nil || rec.Hash != hash {
+			t.Errorf("%s: expected record with %s hash, got %+v", name, hash, rec)
+		}
+	}
+}
+
+type layoutProcessor struct{}
+
+func (p layoutProcessor) Process(page *Page, args []string) error { return nil }
+func (p layoutProcessor) Description() string                     { return "" }
+func (p layoutProcessor) Mode() int                               { return 0 }
+func (p layoutProcessor) Templates(page *Page, args []string) []string {
+	return args
+}
+
+func TestChangeReason(t *testing.T) {
+	dir := t.TempDir()
+	site := &Site{
+		Template: template.Must(template.New("page.tmpl").Parse(
+			`{{ define "other.tmpl" }}{{ end }}`)),
+		Processors:     ProcessorMap{"layout": layoutProcessor{}},
+		templateFiles:  map[string]string{"page.tmpl": "t", "other.tmpl": "t"},
+		templateHashes: map[string]string{"t": "aaa"},
+	}
+	site.Source = filepath.Join(dir, "src")
+	site.Output = filepath.Join(dir, "out")
+	page := &Page{
+		Site:       site,
+		Source:     "a.md",
+		Path:       "a.html",
+		Pattern:    "*.md",
+		Rule:       &Rule{Commands: CommandList{"layout page.tmpl"}},
+		fromSource: true,
+	}
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(dir, fn, content string) {
+		path := filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(site.Source, page.Source, "hello")
+	write(site.Output, page.Path, "hello")
+
+	db, err := OpenBuildDB(filepath.Join(dir, DatabaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// check returns reason and commits current record, as if page was built
+	check := func() string {
+		reason := db.changeReason(page)
+		db.Pages[page.Path] = db.fresh[page.Path]
+		return reason
+	}
+
+	if reason := check(); reason != "page is not in build database" {
+		t.Errorf("unexpected reason for a new page: %q", reason)
+	}
+	if reason := check(); reason != "" {
+		t.Errorf("expected page to be unchanged, got %q", reason)
+	}
+
+	// hashes are cached by size and mtime, so content has the same mtime,
+	// but different size
+	write(site.Source, page.Source, "hello, world")
+	if reason := check(); reason != "source content changed" {
+		t.Errorf("expected content change, got %q", reason)
+	}
+
+	touched := time.Now()
+	if err := os.Chtimes(page.FullPath(), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	if reason := check(); reason != "" {
+		t.Errorf("expected touched page to be unchanged, got %q", reason)
+	}
+
+	page.Rule.Commands = CommandList{"layout page.tmpl", "layout other.tmpl"}
+	if reason := check(); reason != "rule changed" {
+		t.Errorf("expected rule change, got %q", reason)
+	}
+
+	site.templateHashes["t"] = "bbb"
+	if reason := check(); reason != "template t changed" {
+		t.Errorf("expected template change, got %q", reason)
+	}
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	config := &SiteConfig{
+		Rules: RuleMap{"*.md": {{Commands: CommandList{"markdown"}}}},
+		Other: map[string]string{"Sha": "aaa", "Env_allow": "GOSTATIC_TEST_*"},
+	}
+	fingerprint := func() string {
+		fp, err := configFingerprint(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	base := fingerprint()
+	config.Other["Sha"] = "bbb"
+	if fingerprint() == base {
+		t.Error("expected constant from environment to change fingerprint")
+	}
+
+	base = fingerprint()
+	os.Setenv("GOSTATIC_OTHER", "x")
+	defer os.Unsetenv("GOSTATIC_OTHER")
+	if fingerprint() != base {
+		t.Error("expected variables not in ENV_ALLOW to be ignored")
+	}
+	os.Setenv("GOSTATIC_TEST_SHA", "x")
+	defer os.Unsetenv("GOSTATIC_TEST_SHA")
+	if fingerprint() == base {
+		t.Error("expected variables in ENV_ALLOW to change fingerprint")
+	}
+
+	base = fingerprint()
+	config.Rules["*.md"][0].Commands = CommandList{"markdown", "template page"}
+	if fingerprint() == base {
+		t.Error("expected rule change to change fingerprint")
+	}
+}
@@ -4,11 +4,15 @@
 package gostatic
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"github.com/bmatcuk/doublestar/v4"
@@ -25,6 +29,16 @@ type CommandList []Command
 type Rule struct {
 	Deps     []string
 	Commands CommandList
+
+	index int            // position in config, to break ties between patterns
+	re    *regexp.Regexp // compiled pattern of a regular expression rule
+	lines []configPos    // where each of Commands is defined
+}
+
+// configPos is a line of a config file
+type configPos struct {
+	file string
+	line int
 }
 
 type RuleMap map[string]([]*Rule)
@@ -37,38 +51,152 @@ type SiteConfig struct {
 	Output    string
 	Rules     RuleMap
 	Other     map[string]string
+	Macros    map[string]*Macro `json:",omitempty"`
 	changedAt time.Time
+	rules     int // number of rules parsed
+
+	// all config files read, file and line being read now and files rules
+	// were defined in
+	files     []string
+	file      string
+	line      int
+	ruleFiles map[string]string
+
+	// see profile.go
+	options   ConfigOptions
+	overrides []*override
+	profiles  map[string]bool
+	profile   []configLine
+}
+
+// ConfigError is an error in a config file.
+type ConfigError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
 }
 
 // NewSiteConfig parses the given `path' file to a *SiteConfig. Will return a nil
 // pointer plus the non-nil error if the parsing has failed.
-func NewSiteConfig(path string) (*SiteConfig, error) {
-	source, err := ioutil.ReadFile(path)
+func NewSiteConfig(path string, opts ConfigOptions) (*SiteConfig, error) {
+	defines, err := defineOverrides(opts.Defines)
 	if err != nil {
 		return nil, err
 	}
+	cfg, err := parseConfig(path, opts, defines)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Profile == "" {
+		if err = cfg.applyOverrides(); err != nil {
+			return nil, err
+		}
+		return cfg, cfg.expandMacros()
+	}
 
-	stat, err := os.Stat(path)
+	if !cfg.profiles[opts.Profile] {
+		return nil, fmt.Errorf("profile '%s' is not defined", opts.Profile)
+	}
+	// constants from profile override ones from config, so config is read
+	// again with them known beforehand; defines take precedence over both
+	defines, _ = defineOverrides(opts.Defines)
+	cfg, err = parseConfig(path, opts,
+		append(defines, profileOverrides(cfg.profile)...))
 	if err != nil {
 		return nil, err
 	}
+	if err = cfg.applyOverrides(); err != nil {
+		return nil, err
+	}
+	// commands removed by profile could come from macros, while added ones
+	// can use them
+	if err = cfg.expandMacros(); err != nil {
+		return nil, err
+	}
+	if err = cfg.applyProfile(); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.expandMacros()
+}
 
+func parseConfig(path string, opts ConfigOptions, overrides []*override) (*SiteConfig, error) {
 	basepath, _ := filepath.Split(path)
 	cfg := &SiteConfig{
 		Rules:     make(RuleMap),
 		Other:     make(map[string]string),
 		Base:      basepath,
-		changedAt: stat.ModTime(),
+		options:   opts,
+		overrides: overrides,
+		profiles:  make(map[string]bool),
+	}
+
+	err := cfg.parseFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFile reads config from path into cfg, stack is a list of files which
+// include this one, to detect cycles
+func (cfg *SiteConfig) parseFile(path string, stack []string) error {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, fn := range stack {
+		if fn == abspath {
+			return fmt.Errorf("config file '%s' is included recursively", path)
+		}
 	}
+	// overlapping includes read a file once, so its rules are not doubled
+	for _, fn := range cfg.files {
+		if other, _ := filepath.Abs(fn); other == abspath {
+			return nil
+		}
+	}
+	stack = append(stack, abspath)
 
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	cfg.files = append(cfg.files, path)
+	parent := cfg.file
+	cfg.file = path
+	defer func() { cfg.file = parent }()
+	if cfg.changedAt.Before(stat.ModTime()) {
+		cfg.changedAt = stat.ModTime()
+	}
+
+	basepath, _ := filepath.Split(path)
 	indent := 0
 	level := 0
 	prefix := regexp.MustCompile("^[ \t]*")
-	comment := regexp.MustCompile(`(^|[^\\])#`)
 
 	var current *Rule
+	var macro *Macro
+	section := ""
+
+	lines := strings.Split(string(source), "\n")
+	for i := 0; i < len(lines); i++ {
+		num := i + 1
+		line := lines[i]
+		cfg.line = num
 
-	for i, line := range strings.Split(string(source), "\n") {
 		// check indent
 		indnew := len(prefix.FindString(line))
 		switch {
@@ -79,53 +207,148 @@ func NewSiteConfig(path string) (*SiteConfig, error) {
 		}
 		indent = indnew
 
-		// remove useless stuff from line
-		line = line[indent:]
-		commentloc := comment.FindIndex([]byte(line))
-		if commentloc != nil {
-			line = line[:commentloc[0]]
+		// remove useless stuff from line, trailing backslash continues line
+		// on the next one
+		if level == 0 {
+			// constants and rule headers are not split into words
+			line = StripValueComment(line[indent:])
+			for IsContinued(line) && i+1 < len(lines) {
+				i++
+				line = joinContinued(line, StripValueComment(lines[i]))
+			}
+		} else {
+			// commands are tokenized later, escapes are handled there;
+			// quotes can span lines, so comment is looked for again
+			line = StripComment(line[indent:])
+			for IsContinued(line) && i+1 < len(lines) {
+				i++
+				line = StripComment(joinContinued(line, lines[i]))
+			}
 		}
-		line = strings.Replace(line, "\\#", "#", -1)
 		line = strings.TrimSpace(line)
 
 		if len(line) == 0 {
 			continue
 		}
 
-		// is this a constant declaration?
-		if level == 0 && strings.Index(line, "=") != -1 {
-			cfg.ParseVariable(basepath, line)
+		// section headers switch between base config and profiles
+		if level == 0 && strings.HasPrefix(line, "[") &&
+			strings.HasSuffix(line, "]") {
+			section, err = cfg.ParseSection(line)
+			if err != nil {
+				return &ConfigError{path, num, err}
+			}
+			current, macro = nil, nil
+			continue
+		}
+
+		// profiles are applied after everything else is read
+		if section != "" {
+			if section == cfg.options.Profile {
+				cfg.profile = append(cfg.profile,
+					configLine{path, num, basepath, level, line})
+			}
+			continue
+		}
+
+		// is this a macro definition?
+		if level == 0 && strings.HasPrefix(line, "define ") {
+			macro, err = cfg.ParseDefine(line)
+			if err != nil {
+				return &ConfigError{path, num, err}
+			}
+			current = nil
+			continue
+		}
+
+		// is this an include of other config files?
+		if level == 0 && (strings.HasPrefix(line, "include ") ||
+			TrimSplitN(line, "=", 2)[0] == "INCLUDE") {
+			err := cfg.ParseInclude(basepath, line, stack)
+			if err != nil {
+				var ce *ConfigError
+				if errors.As(err, &ce) {
+					return err
+				}
+				return &ConfigError{path, num, err}
+			}
+			current, macro = nil, nil
+			continue
+		}
+
+		// is this a constant declaration? Regular expressions can contain `='
+		if level == 0 && !IsRegexPattern(line) &&
+			strings.Index(line, "=") != -1 {
+			err := cfg.ParseVariable(basepath, line)
+			if err != nil {
+				return &ConfigError{path, num, err}
+			}
 			continue
 		}
 
 		// not a constant, then a Rule start?
 		if level == 0 {
-			current = cfg.ParseRule(line)
+			current, err = cfg.ParseRule(line)
+			if err != nil {
+				return &ConfigError{path, num, err}
+			}
+			macro = nil
+			continue
+		}
+
+		if level == 1 && macro != nil {
+			err := macro.ParseCommand(line)
+			if err != nil {
+				return &ConfigError{path, num, err}
+			}
 			continue
 		}
 
 		if level == 1 {
 			if current == nil {
-				return nil, fmt.Errorf("indent without rules, line %d", i+1)
+				return &ConfigError{path, num,
+					errors.New("indent without rules")}
+			}
+			err := current.ParseCommand(cfg, line)
+			if err != nil {
+				return &ConfigError{path, num, err}
 			}
-			current.ParseCommand(cfg, line)
 			continue
 		}
 
-		return nil, fmt.Errorf("unhandled situation on line %d: %s",
-			i+1, line)
+		return &ConfigError{path, num,
+			fmt.Errorf("unhandled situation: %s", line)}
 	}
 
-	return cfg, nil
+	return nil
+}
+
+// Files returns paths of config file and all files it includes
+func (cfg *SiteConfig) Files() []string {
+	return append([]string{}, cfg.files...)
 }
 
 // *** Parsing methods
 
-var VarRe = regexp.MustCompile(`\$\(([^\)]+)\)`)
+var VarRe = regexp.MustCompile(`\$\(([^$()]+)\)`)
 
 func (cfg *SiteConfig) SubVars(s string) string {
 	return VarRe.ReplaceAllStringFunc(s, func(m string) string {
 		name := VarRe.FindStringSubmatch(m)[1]
+		// page fields are substituted for every page, see Command.PageArgs
+		if strings.HasPrefix(name, "page.") {
+			return m
+		}
+		if strings.HasPrefix(name, "env:") {
+			// $(env:NAME) or $(env:NAME:-fallback)
+			bits := strings.SplitN(name[len("env:"):], ":-", 2)
+			value := os.Getenv(bits[0])
+			if value == "" && len(bits) == 2 {
+				return bits[1]
+			}
+			return value
+		}
+
 		switch name {
 		case "TEMPLATES":
 			return strings.Join(cfg.Templates, ", ")
@@ -139,10 +362,14 @@ func (cfg *SiteConfig) SubVars(s string) string {
 	})
 }
 
-func (cfg *SiteConfig) ParseVariable(base string, line string) {
+func (cfg *SiteConfig) ParseVariable(base string, line string) error {
 	bits := TrimSplitN(line, "=", 2)
 	name := bits[0]
 	value := cfg.SubVars(bits[1])
+	if o := cfg.override(name); o != nil {
+		base, value = o.base, cfg.SubVars(o.value)
+		o.used = true
+	}
 
 	switch name {
 	case "TEMPLATES":
@@ -152,7 +379,7 @@ func (cfg *SiteConfig) ParseVariable(base string, line string) {
 			isDir, err := IsDir(path)
 
 			if err != nil {
-				errexit(fmt.Errorf("template does not exist: %s", err))
+				return fmt.Errorf("template does not exist: %s", err)
 			}
 
 			if isDir {
@@ -171,30 +398,113 @@ func (cfg *SiteConfig) ParseVariable(base string, line string) {
 	default:
 		cfg.Other[Capitalize(name)] = value
 	}
+	return nil
+}
+
+// ParseInclude reads config files listed in `include a b' or `INCLUDE = a b'
+// line, paths are relative to base and can be globs
+func (cfg *SiteConfig) ParseInclude(base string, line string, stack []string) error {
+	var value string
+	if strings.HasPrefix(line, "INCLUDE") {
+		value = TrimSplitN(line, "=", 2)[1]
+	} else {
+		value = strings.TrimPrefix(line, "include ")
+	}
+
+	for _, pattern := range NonEmptySplit(cfg.SubVars(value), " ") {
+		pattern = filepath.Join(base, pattern)
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern '%s'", pattern)
+		}
+		// missing files are errors, unless they are globs
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			files = []string{pattern}
+		}
+		for _, fn := range files {
+			if err := cfg.parseFile(fn, stack); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (cfg *SiteConfig) ParseRule(line string) *Rule {
-	bits := TrimSplitN(line, ":", 2)
+// IsRegexPattern tells if rule pattern is a regular expression (`~regexp')
+// rather than a glob
+func IsRegexPattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "~")
+}
+
+// splitRule splits rule line into pattern and dependencies; regular
+// expressions can contain colons, so the last one separates them
+func splitRule(line string) []string {
+	if !IsRegexPattern(line) {
+		return TrimSplitN(line, ":", 2)
+	}
+	i := strings.LastIndex(line, ":")
+	if i == -1 {
+		return []string{line}
+	}
+	return []string{strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])}
+}
+
+func (cfg *SiteConfig) ParseRule(line string) (*Rule, error) {
+	bits := splitRule(line)
 	if len(bits) != 2 {
-		errexit(fmt.Errorf("Cannot parse rule, ':' not found in '%s'", line))
+		return nil, fmt.Errorf("cannot parse rule, ':' not found in '%s'", line)
+	}
+	var re *regexp.Regexp
+	if IsRegexPattern(bits[0]) {
+		var err error
+		// pattern is matched against the whole path
+		re, err = regexp.Compile("^(?:" + bits[0][1:] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule pattern '%s': %v", bits[0], err)
+		}
+	} else if !doublestar.ValidatePattern(bits[0]) {
+		return nil, fmt.Errorf("invalid rule pattern '%s'", bits[0])
 	}
 	deps := NonEmptySplit(cfg.SubVars(bits[1]), " ")
+	for _, dep := range deps {
+		if !doublestar.ValidatePattern(strings.TrimPrefix(dep, "!")) {
+			return nil, fmt.Errorf("invalid dependency pattern '%s'", dep)
+		}
+	}
 	rule := &Rule{
 		Deps:     deps,
 		Commands: make(CommandList, 0),
+		index:    cfg.rules,
+		re:       re,
 	}
+	cfg.rules++
 
-	if _, ok := cfg.Rules[bits[0]]; !ok {
+	if cfg.ruleFiles == nil {
+		cfg.ruleFiles = make(map[string]string)
+	}
+	// several rules with the same pattern in one file produce several pages,
+	// while a rule from other file (i.e. included one) is overridden
+	if _, ok := cfg.Rules[bits[0]]; !ok || cfg.ruleFiles[bits[0]] != cfg.file {
 		cfg.Rules[bits[0]] = make([]*Rule, 0)
 	}
 	cfg.Rules[bits[0]] = append(cfg.Rules[bits[0]], rule)
+	cfg.ruleFiles[bits[0]] = cfg.file
 
-	return rule
+	return rule, nil
 }
 
-func (rule *Rule) ParseCommand(cfg *SiteConfig, line string) {
+func (rule *Rule) ParseCommand(cfg *SiteConfig, line string) error {
 	line = cfg.SubVars(line)
+	cmd := Command(line)
+	if cmd.IsGuarded() && cmd.Body() == "" {
+		return fmt.Errorf("cannot parse condition in '%s', 'if Field=Value: command' expected", line)
+	}
+	if _, err := SplitCommand(string(cmd.Body())); err != nil {
+		return fmt.Errorf("%v: %s", err, line)
+	}
 	rule.Commands = append(rule.Commands, Command(line))
+	rule.lines = append(rule.lines, configPos{cfg.file, cfg.line})
+	return nil
 }
 
 // *** Traversing methods
@@ -203,41 +513,193 @@ func (cmd Command) Matches(prefix Command) bool {
 	return cmd == prefix || strings.HasPrefix(string(cmd), string(prefix)+" ")
 }
 
+// Captures returns groups captured by regular expression of a rule from
+// path, both by number and by name; it's nil for glob rules
+func (rule *Rule) Captures(path string) map[string]string {
+	if rule == nil || rule.re == nil {
+		return nil
+	}
+	m := rule.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil
+	}
+	captures := make(map[string]string, len(m))
+	for i, name := range rule.re.SubexpNames() {
+		captures[strconv.Itoa(i)] = m[i]
+		if name != "" {
+			captures[name] = m[i]
+		}
+	}
+	return captures
+}
+
+// IsDep tells if page matches dependencies of a rule; dependencies starting
+// with `!' exclude pages matched by other ones
 func (rule *Rule) IsDep(page *Page) bool {
+	matched := false
 	for _, dep := range rule.Deps {
-		matches, err := doublestar.Match(dep, page.Source)
-		if err == nil && matches {
-			return true
+		if strings.HasPrefix(dep, "!") {
+			if excluded, _ := doublestar.Match(dep[1:], page.Source); excluded {
+				return false
+			}
+			continue
+		}
+		if !matched {
+			matched, _ = doublestar.Match(dep, page.Source)
 		}
 	}
-	return false
+	return matched
 }
 
-func (rules RuleMap) MatchedRules(path string) (string, []*Rule) {
-	if rules[path] != nil {
-		return path, rules[path]
+// kinds of matches of a pattern with a path, in order of precedence
+const (
+	matchPath = iota
+	matchName
+	matchGlobPath
+	matchGlobName
+	matchNone
+)
+
+// RuleMatch is a pattern which matches some path.
+type RuleMatch struct {
+	Pattern     string
+	Rules       []*Rule
+	kind        int
+	specificity int
+}
+
+// Specificity returns number of literal (not wildcard) characters in a glob
+// pattern: more specific patterns take precedence over less specific ones.
+func Specificity(pattern string) int {
+	if IsRegexPattern(pattern) {
+		return regexSpecificity(pattern[1:])
+	}
+	count := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '\\':
+			i++
+			count++
+		case '[', '{':
+			// character classes and alternatives match something variable
+			closing := byte(']')
+			if pattern[i] == '{' {
+				closing = '}'
+			}
+			if end := strings.IndexByte(pattern[i:], closing); end != -1 {
+				i += end
+			} else {
+				count++
+			}
+		default:
+			count++
+		}
 	}
+	return count
+}
 
-	_, name := filepath.Split(path)
-	if rules[name] != nil {
-		return name, rules[name]
+// regexSpecificity counts literal characters, which regular expression
+// always matches
+func regexSpecificity(expr string) int {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return 0
 	}
+	var count func(re *syntax.Regexp) int
+	count = func(re *syntax.Regexp) int {
+		switch re.Op {
+		case syntax.OpLiteral:
+			return len(re.Rune)
+		case syntax.OpConcat, syntax.OpCapture:
+			n := 0
+			for _, sub := range re.Sub {
+				n += count(sub)
+			}
+			return n
+		}
+		return 0
+	}
+	return count(re)
+}
 
+// Matches returns all patterns matching path, in order of precedence: exact
+// path match, exact name match, glob (or regular expression) path match,
+// glob name match. Globs of the same kind are ordered by their Specificity
+// and then by position in config.
+func (rules RuleMap) Matches(path string) []RuleMatch {
+	_, name := filepath.Split(path)
+
+	matches := make([]RuleMatch, 0)
 	for pat, subset := range rules {
-		matched, err := doublestar.Match(pat, path)
-		errhandle(err)
-		if matched {
-			return pat, subset
+		kind := matchNone
+		switch {
+		case pat == path:
+			kind = matchPath
+		case pat == name:
+			kind = matchName
+		case IsRegexPattern(pat):
+			if subset[0].re.MatchString(path) {
+				kind = matchGlobPath
+			}
+		default:
+			// patterns are validated while parsing config, so errors are
+			// impossible
+			if matched, _ := doublestar.Match(pat, path); matched {
+				kind = matchGlobPath
+			} else if matched, _ := doublestar.Match(pat, name); matched {
+				kind = matchGlobName
+			}
+		}
+		if kind != matchNone {
+			matches = append(matches, RuleMatch{pat, subset, kind, Specificity(pat)})
 		}
 	}
 
-	for pat, subset := range rules {
-		matched, err := doublestar.Match(pat, name)
-		errhandle(err)
-		if matched {
-			return pat, subset
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
 		}
+		if a.specificity != b.specificity {
+			return a.specificity > b.specificity
+		}
+		return a.Rules[0].index < b.Rules[0].index
+	})
+	return matches
+}
+
+// Ambiguous tells if winning match is not more specific than the next one, so
+// that only order of rules in config decides between them
+func Ambiguous(matches []RuleMatch) bool {
+	return len(matches) > 1 &&
+		matches[0].kind == matches[1].kind &&
+		matches[0].specificity == matches[1].specificity
+}
+
+// RuleChoice describes which of several matching rules is used for path and
+// why, it's empty if there is nothing to choose from
+func RuleChoice(path string, matches []RuleMatch) string {
+	if len(matches) < 2 {
+		return ""
+	}
+	if Ambiguous(matches) {
+		return fmt.Sprintf("%s matches rules '%s' and '%s' equally well, "+
+			"using the first one in config",
+			path, matches[0].Pattern, matches[1].Pattern)
 	}
+	others := make([]string, 0, len(matches)-1)
+	for _, m := range matches[1:] {
+		others = append(others, "'"+m.Pattern+"'")
+	}
+	return fmt.Sprintf("%s uses rule '%s', which overrides %s",
+		path, matches[0].Pattern, strings.Join(others, ", "))
+}
 
-	return "", nil
+func (rules RuleMap) MatchedRules(path string) (string, []*Rule) {
+	matches := rules.Matches(path)
+	if len(matches) == 0 {
+		return "", nil
+	}
+	return matches[0].Pattern, matches[0].Rules
 }
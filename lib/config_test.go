@@ -0,0 +1,454 @@
+package gostatic
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRuleMatchesPrecedence(t *testing.T) {
+	cfg := &SiteConfig{Rules: make(RuleMap)}
+	for _, line := range []string{
+		"*.md:", "blog/*.md:", "blog/**:", "blog/o*.md:", "blog/*e.md:", "one.md:",
+	} {
+		if _, err := cfg.ParseRule(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		pattern, _ := cfg.Rules.MatchedRules("blog/one.md")
+		if pattern != "one.md" {
+			t.Fatalf("expected exact name match to win, got %s", pattern)
+		}
+	}
+
+	matches := cfg.Rules.Matches("blog/one.md")
+	expected := []string{"one.md", "blog/o*.md", "blog/*e.md", "blog/*.md", "blog/**", "*.md"}
+	if len(matches) != len(expected) {
+		t.Fatalf("expected %d matches, got %d", len(expected), len(matches))
+	}
+	for i, m := range matches {
+		if m.Pattern != expected[i] {
+			t.Errorf("match %d: expected %s, got %s", i, expected[i], m.Pattern)
+		}
+	}
+	if Ambiguous(matches) {
+		t.Error("exact match should not be ambiguous")
+	}
+	if !Ambiguous(matches[1:]) {
+		t.Error("blog/o*.md and blog/*e.md should be ambiguous")
+	}
+
+	warning := "blog/one.md matches rules 'blog/o*.md' and 'blog/*e.md' " +
+		"equally well, using the first one in config"
+	if s := RuleChoice("blog/one.md", matches[1:]); s != warning {
+		t.Errorf("expected warning %q, got %q", warning, s)
+	}
+}
+
+// blog/*.md is more specific than blog/** wherever it is in config
+func TestRuleMatchesOrder(t *testing.T) {
+	for _, lines := range [][]string{
+		{"blog/*.md:", "blog/**:"},
+		{"blog/**:", "blog/*.md:"},
+	} {
+		cfg := &SiteConfig{Rules: make(RuleMap)}
+		for _, line := range lines {
+			if _, err := cfg.ParseRule(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		matches := cfg.Rules.Matches("blog/one.md")
+		if len(matches) != 2 || matches[0].Pattern != "blog/*.md" || Ambiguous(matches) {
+			t.Errorf("%v: expected blog/*.md to win without ambiguity, got %v",
+				lines, matches)
+		}
+		expected := "blog/one.md uses rule 'blog/*.md', which overrides 'blog/**'"
+		if s := RuleChoice("blog/one.md", matches); s != expected {
+			t.Errorf("%v: expected %q, got %q", lines, expected, s)
+		}
+		if s := RuleChoice("blog/one.md", matches[:1]); s != "" {
+			t.Errorf("expected no choice for a single rule, got %q", s)
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	cases := map[string]int{
+		"*.md":                    3,
+		"blog/*.md":               8,
+		"blog/**":                 5,
+		"*.{md,txt}":              1,
+		"page[0-9].*":             5,
+		`\*.md`:                   4,
+		`~^docs/v(\d+)/(.+)\.md$`: 10,
+	}
+	for pattern, expected := range cases {
+		if got := Specificity(pattern); got != expected {
+			t.Errorf("Specificity(%q) = %d, expected %d", pattern, got, expected)
+		}
+	}
+}
+
+func TestRegexRules(t *testing.T) {
+	cfg := &SiteConfig{Rules: make(RuleMap)}
+	for _, line := range []string{
+		"docs/**:", `~^docs/v(\d+)/(?P<name>[^/]+)\.md$:`,
+	} {
+		if _, err := cfg.ParseRule(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cfg.ParseRule("~docs/(.md:"); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+
+	pattern, rules := cfg.Rules.MatchedRules("docs/v2/intro.md")
+	if pattern != `~^docs/v(\d+)/(?P<name>[^/]+)\.md$` {
+		t.Fatalf("expected regular expression to win, got %s", pattern)
+	}
+	page := &Page{Captures: rules[0].Captures("docs/v2/intro.md")}
+	if page.Captures["1"] != "2" || page.Captures["name"] != "intro" {
+		t.Errorf("unexpected captures %q", page.Captures)
+	}
+
+	// regular expressions match the whole path
+	if _, err := cfg.ParseRule(`~docs/(.+)\.md:`); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"olddocs/x.md", "docs/x.md.bak"} {
+		if pattern, _ := cfg.Rules.MatchedRules(path); pattern == `~docs/(.+)\.md` {
+			t.Errorf("%s: expected regular expression not to match", path)
+		}
+	}
+	if pattern, _ := cfg.Rules.MatchedRules("docs/x.md"); pattern != `~docs/(.+)\.md` {
+		t.Errorf("expected unanchored expression to match, got %s", pattern)
+	}
+
+	cmd := Command("rename /v$1/${name}/index.html")
+	args, err := cmd.PageArgs(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "/v2/intro/index.html" {
+		t.Errorf("expected captures to be substituted, got %q", args)
+	}
+	cmd = Command("rename $3.html")
+	if _, err := cmd.PageArgs(page); err == nil {
+		t.Error("expected error for unknown group")
+	}
+
+	cmd = Command(`:sh -c "echo ${HOME} $1" $3`)
+	args, err = cmd.PageArgs(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"sh", "-c", "echo ${HOME} $1", "$3"}) {
+		t.Errorf("expected external command to be left as is, got %q", args)
+	}
+}
+
+func TestConfigInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("common/rules", "OUTPUT = out\nTITLE = common\n\n*.md:\n\tmarkdown\n\n*.css:\n\tcopy\n")
+	write("config", "include common/*\nTITLE = site\n\n*.md:\n\tconfig\n\tmarkdown\n")
+
+	cfg, err := NewSiteConfig(filepath.Join(dir, "config"), ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Output != filepath.Join(dir, "common", "out") {
+		t.Errorf("paths should be relative to included file, got %s", cfg.Output)
+	}
+	if cfg.Other["Title"] != "site" {
+		t.Errorf("constant should be overridden, got %s", cfg.Other["Title"])
+	}
+	if len(cfg.Rules["*.md"]) != 1 || len(cfg.Rules["*.md"][0].Commands) != 2 {
+		t.Errorf("rule should be overridden, got %v", cfg.Rules["*.md"])
+	}
+	if cfg.Rules["*.css"] == nil {
+		t.Error("rule from included file is missing")
+	}
+
+	// overlapping globs include common/rules once
+	write("config", "include common/rules common/*\n")
+	cfg, err = NewSiteConfig(filepath.Join(dir, "config"), ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules["*.md"]) != 1 {
+		t.Errorf("included file should be read once, got %v", cfg.Rules["*.md"])
+	}
+	if len(cfg.Files()) != 2 {
+		t.Errorf("expected config and included file, got %v", cfg.Files())
+	}
+
+	write("common/rules", "include ../config\n")
+	_, err = NewSiteConfig(filepath.Join(dir, "config"), ConfigOptions{})
+	var ce *ConfigError
+	if !errors.As(err, &ce) || ce.Line != 1 || filepath.Base(ce.File) != "rules" {
+		t.Errorf("expected error about recursive include in rules:1, got %v", err)
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, []byte(`URL = http://localhost
+FEED = $(URL)/feed.xml
+
+*.js:
+	:uglifyjs
+	ext .min.js
+
+[profile dev]
+URL = http://dev
+
+*.js:
+	- :uglifyjs
+
+*.md: *.tmpl
+	markdown
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewSiteConfig(path, ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Other["Feed"] != "http://localhost/feed.xml" || len(cfg.Rules["*.js"][0].Commands) != 2 {
+		t.Errorf("profile should not be applied unless asked, got %v", cfg.Other)
+	}
+
+	cfg, err = NewSiteConfig(path, ConfigOptions{Profile: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Other["Feed"] != "http://dev/feed.xml" {
+		t.Errorf("profile constants should be used in config, got %v", cfg.Other)
+	}
+	if cmds := cfg.Rules["*.js"][0].Commands; len(cmds) != 1 || cmds[0] != "ext .min.js" {
+		t.Errorf("command should be removed by profile, got %v", cmds)
+	}
+	if cfg.Rules["*.md"] == nil || cfg.Rules["*.md"][0].Deps[0] != "*.tmpl" {
+		t.Error("profile should be able to add rules")
+	}
+
+	cfg, err = NewSiteConfig(path, ConfigOptions{Profile: "dev",
+		Defines: []string{"URL=http://ci", "BUILD = 42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Other["Feed"] != "http://ci/feed.xml" || cfg.Other["Build"] != "42" {
+		t.Errorf("defines should override profile and config, got %v", cfg.Other)
+	}
+
+	cfg, err = NewSiteConfig(path, ConfigOptions{Defines: []string{"url=http://ci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Other["Feed"] != "http://ci/feed.xml" {
+		t.Errorf("defines should match names regardless of case, got %v", cfg.Other)
+	}
+
+	_, err = NewSiteConfig(path, ConfigOptions{Profile: "prod"})
+	if err == nil {
+		t.Error("expected error for unknown profile")
+	}
+}
+
+func TestConfigCommandLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, []byte(`TITLE = C\# notes # comment
+AUTHOR = Bob's \
+    site # comment
+
+*.js:
+	:sh -c "uglifyjs | \
+	    gzip" # comment
+	rename 'my page.js'
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewSiteConfig(path, ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Other["Title"] != "C# notes" {
+		t.Errorf("expected 'C# notes', got %q", cfg.Other["Title"])
+	}
+	if cfg.Other["Author"] != "Bob's site" {
+		t.Errorf("expected \"Bob's site\", got %q", cfg.Other["Author"])
+	}
+	cmds := cfg.Rules["*.js"][0].Commands
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, got %q", cmds)
+	}
+	if args := cmds[0].Args(); len(args) != 3 || args[2] != "uglifyjs | gzip" {
+		t.Errorf("expected continued command, got %q", args)
+	}
+	if args := cmds[1].Args(); len(args) != 1 || args[0] != "my page.js" {
+		t.Errorf("expected quoted argument, got %q", args)
+	}
+
+	ioutil.WriteFile(path, []byte("*.js:\n\trename 'a.js\n"), 0644)
+	if _, err := NewSiteConfig(path, ConfigOptions{}); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestCommandPageArgs(t *testing.T) {
+	page := &Page{
+		PageHeader: PageHeader{Title: "Hello world", Other: map[string]string{"Slug": "hello"}},
+	}
+
+	cmd := Command(`template $(page.Layout|post) "$(page.Title)" $(page.Slug).html`)
+	args, err := cmd.PageArgs(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"post", "Hello world", "hello.html"}
+	if len(args) != 3 || args[0] != expected[0] || args[1] != expected[1] || args[2] != expected[2] {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	cmd = Command("template $(page.Layout)")
+	if _, err := cmd.PageArgs(page); err == nil {
+		t.Error("expected error for empty field without default")
+	}
+}
+
+func TestCommandGuard(t *testing.T) {
+	page := &Page{Source: "blog/post.en.md",
+		PageHeader: PageHeader{Other: map[string]string{"Draft": "true"}}}
+
+	var testTable = []struct {
+		cmd     Command
+		applies bool
+		name    string
+	}{
+		{"tags tags/*.tag", true, "tags"},
+		{"if Hide=false: tags tags/*.tag", true, "tags"},
+		{"if Hide=true: tags tags/*.tag", false, "tags"},
+		{"unless Other.Draft=true: template post", false, "template"},
+		{"if Draft=true: template draft", true, "template"},
+		{"if Source=**/*.en.md: rename en.html", true, "rename"},
+	}
+
+	for _, s := range testTable {
+		if s.cmd.Applies(page) != s.applies {
+			t.Errorf("%s: expected applies to be %v", s.cmd, s.applies)
+		}
+		if s.cmd.Name() != s.name {
+			t.Errorf("%s: expected name %s, got %s", s.cmd, s.name, s.cmd.Name())
+		}
+	}
+
+	rule := &Rule{}
+	if err := rule.ParseCommand(&SiteConfig{}, "if Hide: tags"); err == nil {
+		t.Error("expected error for malformed condition")
+	}
+}
+
+func TestConfigMacros(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, []byte(`LAYOUT = page
+
+*.md:
+	unless Hide=true: article
+	post "My Blog"
+
+define article layout=$(LAYOUT):
+	config
+	ext .html
+	template $(layout)
+
+define post title:
+	article post
+	rename $(title).html
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewSiteConfig(path, ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CommandList{
+		"unless Hide=true: config",
+		"unless Hide=true: ext .html",
+		"unless Hide=true: template page",
+		"config",
+		"ext .html",
+		"template post",
+		"rename 'My Blog'.html",
+	}
+	cmds := cfg.Rules["*.md"][0].Commands
+	if len(cmds) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, cmds)
+	}
+	for i := range cmds {
+		if cmds[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], cmds[i])
+		}
+	}
+	if args := cmds[6].Args(); len(args) != 1 || args[0] != "My Blog.html" {
+		t.Errorf("expected quoted argument, got %q", args)
+	}
+
+	// errors point to the line macro is used at
+	var testTable = []struct {
+		config string
+		line   int
+	}{
+		{"define a:\n\tb\ndefine b:\n\ta\n*.md:\n\tconfig\n\ta\n", 7},
+		{"define a x:\n\text $(x)\n\n*.md:\n\ta\n", 5},
+		{"define a x:\n\text $(x)\n\n*.md:\n\ta 1 2\n", 5},
+	}
+	for _, s := range testTable {
+		ioutil.WriteFile(path, []byte(s.config), 0644)
+		_, err := NewSiteConfig(path, ConfigOptions{})
+		var ce *ConfigError
+		if !errors.As(err, &ce) || ce.File != path || ce.Line != s.line {
+			t.Errorf("expected error at %s:%d, got %v", path, s.line, err)
+		}
+	}
+}
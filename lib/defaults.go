@@ -0,0 +1,154 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultsName is a file with page config (in YAML), which is used as
+// defaults for pages in its directory and below
+const DefaultsName = "_defaults.yaml"
+
+// pageDefaults is page config from DefaultsName files of a directory and all
+// of its parents
+type pageDefaults struct {
+	values  map[string]interface{}
+	files   []string // from root to leaf
+	modTime time.Time
+	hash    string
+}
+
+// readDefaults returns defaults for a directory relative to source, must be
+// called with defaultsMx locked
+func (site *Site) readDefaults(dir string) (*pageDefaults, error) {
+	if d, ok := site.defaults[dir]; ok {
+		return d, nil
+	}
+
+	d := &pageDefaults{values: make(map[string]interface{})}
+	if dir != "." && dir != "/" {
+		parent, err := site.readDefaults(path.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+		d = parent
+	}
+
+	fn := filepath.Join(site.Source, filepath.FromSlash(dir), DefaultsName)
+	stat, err := os.Stat(fn)
+	if os.IsNotExist(err) {
+		site.defaults[dir] = d
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+
+	modTime := d.modTime
+	if modTime.Before(stat.ModTime()) {
+		modTime = stat.ModTime()
+	}
+	d = &pageDefaults{
+		values:  mergeParams(d.values, m),
+		files:   append(append([]string{}, d.files...), fn),
+		modTime: modTime,
+		hash:    hashString(d.hash + "\n" + string(data)),
+	}
+	site.defaults[dir] = d
+	return d, nil
+}
+
+// pageDefaults returns defaults for a page
+func (page *Page) pageDefaults() (*pageDefaults, error) {
+	site := page.Site
+	if site == nil {
+		return &pageDefaults{}, nil
+	}
+	site.defaultsMx.Lock()
+	defer site.defaultsMx.Unlock()
+	if site.defaults == nil {
+		site.defaults = make(map[string]*pageDefaults)
+	}
+	return site.readDefaults(path.Dir(page.Source))
+}
+
+// SetHeader sets page config, merged on top of defaults from DefaultsName
+// files in directory of a page and its parents
+func (page *Page) SetHeader(header *PageHeader) error {
+	d, err := page.pageDefaults()
+	if err != nil {
+		return err
+	}
+	if len(d.values) == 0 {
+		page.PageHeader = *header
+		return nil
+	}
+
+	merged, err := NewPageHeaderFromMap(mergeParams(d.values, header.Params))
+	if err != nil {
+		return err
+	}
+	page.PageHeader = *merged
+	return nil
+}
+
+// mergeParams returns a copy of base with values from over, nested maps are
+// merged as well; keys are compared case-insensitively, like page config
+// keys are
+func mergeParams(base, over map[string]interface{}) map[string]interface{} {
+	result := copyParam(base).(map[string]interface{})
+	for key, value := range over {
+		value = normalizeParam(copyParam(value))
+		for k := range result {
+			if k != key && strings.EqualFold(k, key) {
+				result[key] = result[k]
+				delete(result, k)
+			}
+		}
+		prev, ok1 := result[key].(map[string]interface{})
+		next, ok2 := value.(map[string]interface{})
+		if ok1 && ok2 {
+			value = mergeParams(prev, next)
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// copyParam makes a deep copy of maps and lists, so that pages don't share
+// them
+func copyParam(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[key] = copyParam(x)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, x := range v {
+			list[i] = copyParam(x)
+		}
+		return list
+	}
+	return value
+}
@@ -0,0 +1,55 @@
+package gostatic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "archive", "2010"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, DefaultsName),
+		[]byte("Author: Site Owner\nlayout: post\nhero:\n  image: default.png\n  alt: Default\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "archive", DefaultsName),
+		[]byte("hide: true\nhero:\n  alt: Archive\n"), 0644)
+
+	site := &Site{SiteConfig: SiteConfig{Source: dir}}
+	page := &Page{Site: site, Source: "archive/2010/old.md"}
+	header, err := ParseHeader("title: Old\nauthor: me\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := page.SetHeader(header); err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Title != "Old" || !page.Hide {
+		t.Errorf("expected title and hide to be set, got %+v", page.PageHeader)
+	}
+	if page.Other["Author"] != "me" || page.Other["Layout"] != "post" {
+		t.Errorf("expected page config over defaults, got %q", page.Other)
+	}
+	if !page.Has("Params.hero.alt", "Archive") || !page.Has("Params.hero.image", "default.png") {
+		t.Errorf("expected nested defaults to be merged, got %v", page.Params["hero"])
+	}
+
+	d, err := page.pageDefaults()
+	if err != nil || len(d.files) != 2 {
+		t.Errorf("expected 2 defaults files, got %v (%v)", d.files, err)
+	}
+
+	other := &Page{Site: site, Source: "index.md"}
+	if err := other.SetHeader(NewPageHeader()); err != nil {
+		t.Fatal(err)
+	}
+	if other.Hide || other.Other["Author"] != "Site Owner" {
+		t.Errorf("expected only root defaults, got %+v", other.PageHeader)
+	}
+}
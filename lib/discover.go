@@ -0,0 +1,482 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// DiscoveredName is the name of a file in the output directory, which keeps
+// dependencies discovered while pages were processed
+const DiscoveredName = ".gostatic-deps"
+
+// DiscoveredDeps describes what other pages a page has read while being
+// processed.
+type DiscoveredDeps struct {
+	// paths of pages which were read
+	Deps []string `json:",omitempty"`
+	// fingerprint of a list of pages, set if page queried it (i.e. with
+	// .Site.Pages.Children), so that new or removed pages are noticed
+	Pages string `json:",omitempty"`
+}
+
+// discover records other as a dependency of page; page is a reader being
+// processed (or nil, then nothing is recorded)
+func (page *Page) discover(other *Page) {
+	if page == nil || other == nil || page == other {
+		return
+	}
+	// only the goroutine processing the page gets here, see Page.Template
+	if page.discovered == nil {
+		page.discovered = make(map[*Page]bool)
+	}
+	page.discovered[other] = true
+}
+
+// examine records result of a query as used by page, which also makes it
+// depend on the list of pages, so that a page added to results is noticed
+func (page *Page) examine(result PageSlice) {
+	if page == nil {
+		return
+	}
+	page.queried = true
+	for _, other := range result {
+		page.discover(other)
+	}
+}
+
+// readPage records pages in value (a page or a list of them) as used by page
+// and returns value untouched, see bindNeighbours
+func (page *Page) readPage(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Page:
+		page.discover(v)
+	case PageSlice:
+		page.examine(v)
+	case *PageSlice:
+		if v != nil {
+			page.examine(*v)
+		}
+	}
+	return value
+}
+
+// pageQuery is a view of site pages given to templates of a page being
+// processed (as `.Site.Pages', see BindPageQueries), which records pages
+// the template reads as its dependencies
+type pageQuery struct {
+	PageSlice
+	reader *Page
+}
+
+// All returns all pages
+func (q *pageQuery) All() PageSlice {
+	q.reader.examine(q.PageSlice)
+	return q.PageSlice
+}
+
+func (q *pageQuery) Prev(cur *Page) *Page {
+	prev := q.PageSlice.Prev(cur)
+	q.reader.examine(PageSlice{prev})
+	return prev
+}
+
+func (q *pageQuery) Next(cur *Page) *Page {
+	next := q.PageSlice.Next(cur)
+	q.reader.examine(PageSlice{next})
+	return next
+}
+
+func (q *pageQuery) Children(root string) *PageSlice {
+	children := q.PageSlice.Children(root)
+	q.reader.examine(*children)
+	return children
+}
+
+func (q *pageQuery) WithTag(tag string) *PageSlice {
+	tagged := q.PageSlice.WithTag(tag)
+	q.reader.examine(*tagged)
+	return tagged
+}
+
+func (q *pageQuery) HasPage(check func(page *Page) bool) bool {
+	for _, page := range q.PageSlice {
+		if check(page) {
+			q.reader.examine(PageSlice{page})
+			return true
+		}
+	}
+	q.reader.examine(nil)
+	return false
+}
+
+func (q *pageQuery) BySource(s string) *Page {
+	page := q.PageSlice.BySource(s)
+	q.reader.discover(page)
+	return page
+}
+
+func (q *pageQuery) ByPath(s string) *Page {
+	page := q.PageSlice.ByPath(s)
+	q.reader.discover(page)
+	return page
+}
+
+func (q *pageQuery) GlobSource(pattern string) *PageSlice {
+	found := q.PageSlice.GlobSource(pattern)
+	q.reader.examine(*found)
+	return found
+}
+
+func (q *pageQuery) Where(field, value string) *PageSlice {
+	found := q.PageSlice.Where(field, value)
+	q.reader.examine(*found)
+	return found
+}
+
+func (q *pageQuery) WhereNot(field, value string) *PageSlice {
+	found := q.PageSlice.WhereNot(field, value)
+	q.reader.examine(*found)
+	return found
+}
+
+// BindPageQueries makes `.Site.Pages' in templates (starting with `.' or a
+// variable) a call to `pages' function, which Page.Template binds to a page
+// being processed, so that pages it reads are recorded. Field chains are
+// kept: `.Site.Pages.Children "blog/"' becomes `(pages).Children "blog/"',
+// and `.Site.Pages' by itself becomes `(pages).All'. Other reads of pages
+// (`.Prev', `.Next' and `.Pages' fields of anything) are passed through
+// `readPage', see bindNeighbours. Only t is changed, not templates associated
+// with it.
+func BindPageQueries(t *template.Template) {
+	if t.Tree != nil && t.Tree.Root != nil {
+		bindQueries(t.Tree.Root)
+	}
+}
+
+func bindQueries(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			bindQueries(child)
+		}
+	case *parse.ActionNode:
+		bindQueries(n.Pipe)
+	case *parse.IfNode:
+		bindBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		bindBranch(&n.BranchNode)
+	case *parse.WithNode:
+		bindBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		bindQueries(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			if isReadPage(cmd) {
+				// bound already
+				continue
+			}
+			for i, arg := range cmd.Args {
+				// last field of a command's function is a method call
+				call := i == 0 && len(cmd.Args) > 1
+				if bound := bindArg(arg, call); bound != arg {
+					cmd.Args[i] = bound
+				}
+			}
+		}
+	}
+}
+
+func bindBranch(n *parse.BranchNode) {
+	bindQueries(n.Pipe)
+	bindQueries(n.List)
+	if n.ElseList != nil {
+		bindQueries(n.ElseList)
+	}
+}
+
+// bindArg returns argument of a command with `.Site.Pages' replaced and
+// reads of pages wrapped, call is true if last field is a method called with
+// arguments
+func bindArg(arg parse.Node, call bool) parse.Node {
+	switch n := arg.(type) {
+	case *parse.ChainNode:
+		if bound := bindArg(n.Node, false); bound != n.Node {
+			n.Node = bound
+		}
+		return bindNeighbours(arg, call)
+	case *parse.PipeNode:
+		bindQueries(n)
+		return arg
+	}
+	return bindNeighbours(bindSitePages(arg), call)
+}
+
+// bindSitePages replaces `.Site.Pages' with `(pages)'
+func bindSitePages(arg parse.Node) parse.Node {
+	var pos parse.Pos
+	var rest []string
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		if len(n.Ident) < 2 || n.Ident[0] != "Site" || n.Ident[1] != "Pages" {
+			return arg
+		}
+		pos, rest = n.Pos, n.Ident[2:]
+	case *parse.VariableNode:
+		if len(n.Ident) < 3 || n.Ident[1] != "Site" || n.Ident[2] != "Pages" {
+			return arg
+		}
+		pos, rest = n.Pos, n.Ident[3:]
+	default:
+		return arg
+	}
+
+	if len(rest) == 0 {
+		rest = []string{"All"}
+	}
+	call := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos,
+		Args: []parse.Node{parse.NewIdentifier("pages").SetPos(pos)}}
+	chain := &parse.ChainNode{NodeType: parse.NodeChain, Pos: pos,
+		Node: &parse.PipeNode{NodeType: parse.NodePipe, Pos: pos,
+			Cmds: []*parse.CommandNode{call}}}
+	for _, field := range rest {
+		chain.Add("." + field)
+	}
+	return chain
+}
+
+// neighbourFields are fields, which return other pages when read from a page
+// (`.Prev', `.Next') or paginator (`.Pages')
+var neighbourFields = map[string]bool{"Prev": true, "Next": true, "Pages": true}
+
+// bindNeighbours wraps field chain up to first of neighbourFields in a call
+// of `readPage': `.Prev.Url' becomes `(readPage .Prev).Url'. Types are not
+// known while parsing, so readPage ignores values which are not pages.
+func bindNeighbours(arg parse.Node, call bool) parse.Node {
+	var pos parse.Pos
+	var fields []string
+	var start int
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		pos, fields = n.Pos, n.Ident
+	case *parse.VariableNode:
+		pos, fields, start = n.Pos, n.Ident, 1
+	case *parse.ChainNode:
+		pos, fields = n.Pos, n.Field
+	default:
+		return arg
+	}
+
+	i := start
+	for ; i < len(fields); i++ {
+		if neighbourFields[fields[i]] && !(call && i == len(fields)-1) {
+			break
+		}
+	}
+	if i == len(fields) {
+		return arg
+	}
+
+	var read parse.Node
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		read = &parse.FieldNode{NodeType: parse.NodeField, Pos: pos,
+			Ident: fields[:i+1]}
+	case *parse.VariableNode:
+		read = &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos,
+			Ident: fields[:i+1]}
+	case *parse.ChainNode:
+		read = &parse.ChainNode{NodeType: parse.NodeChain, Pos: pos,
+			Node: n.Node, Field: fields[:i+1]}
+	}
+	wrapped := &parse.PipeNode{NodeType: parse.NodePipe, Pos: pos,
+		Cmds: []*parse.CommandNode{{NodeType: parse.NodeCommand, Pos: pos,
+			Args: []parse.Node{parse.NewIdentifier("readPage").SetPos(pos), read}}}}
+	if i == len(fields)-1 {
+		return wrapped
+	}
+
+	chain := &parse.ChainNode{NodeType: parse.NodeChain, Pos: pos, Node: wrapped}
+	for _, field := range fields[i+1:] {
+		chain.Add("." + field)
+	}
+	return bindNeighbours(chain, call)
+}
+
+// isReadPage tells if command is a call of `readPage' made by bindNeighbours
+func isReadPage(cmd *parse.CommandNode) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && ident.Ident == "readPage"
+}
+
+// pageSet is a fingerprint of a list of pages collected from source
+func (site *Site) pageSet() string {
+	paths := make([]string, 0, len(site.Pages))
+	for _, page := range site.Pages {
+		paths = append(paths, page.Path)
+	}
+	sort.Strings(paths)
+	return hashString(strings.Join(paths, "\n"))
+}
+
+func (site *Site) discoveredPath() string {
+	return filepath.Join(site.Output, DiscoveredName)
+}
+
+func (site *Site) readDiscovered() (map[string]*DiscoveredDeps, error) {
+	discovered := make(map[string]*DiscoveredDeps)
+	data, err := ioutil.ReadFile(site.discoveredPath())
+	if os.IsNotExist(err) {
+		return discovered, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &discovered)
+	if err != nil {
+		return nil, fmt.Errorf("broken dependencies file '%s': %v",
+			site.discoveredPath(), err)
+	}
+	return discovered, nil
+}
+
+// DiscoveredDeps returns dependencies page has discovered while being
+// processed, or ones recorded by previous build if it was not processed
+func (page *Page) DiscoveredDeps() *DiscoveredDeps {
+	if !page.processed || page.Site.Failed(page) {
+		return page.Site.discovered[page.Path]
+	}
+
+	deps := &DiscoveredDeps{}
+	for other := range page.discovered {
+		// pages created by processors are not there when next build checks
+		// for changes, and depend on sources anyway
+		if other.fromSource {
+			deps.Deps = append(deps.Deps, other.Path)
+		}
+	}
+	sort.Strings(deps.Deps)
+	if page.queried {
+		deps.Pages = page.Site.pagesFingerprint
+	}
+	if deps.Deps == nil && deps.Pages == "" {
+		return nil
+	}
+	return deps
+}
+
+// discoveredReason tells which of discovered dependencies has changed
+func (page *Page) discoveredReason() string {
+	deps := page.Site.discovered[page.Path]
+	if deps == nil {
+		return ""
+	}
+	if deps.Pages != "" && deps.Pages != page.Site.pagesFingerprint {
+		return "list of pages changed"
+	}
+	for _, path := range deps.Deps {
+		dep := page.Site.Pages.ByPath(path)
+		if dep == nil {
+			return "discovered dependency " + path + " is gone"
+		}
+		if dep != page && dep.Changed() {
+			page.changedBy = dep
+			return "discovered dependency " + dep.Source + " changed"
+		}
+	}
+	return ""
+}
+
+func (site *Site) saveDiscovered() error {
+	discovered := make(map[string]*DiscoveredDeps)
+	for _, page := range site.Pages {
+		if deps := page.DiscoveredDeps(); deps != nil {
+			discovered[page.Path] = deps
+		}
+	}
+
+	data, err := json.MarshalIndent(discovered, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(site.Output, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(site.discoveredPath(), data, 0644)
+}
+
+// warnUndeclared warns about pages, which were used by processed pages, but
+// are not covered by dependencies of their rules
+func (site *Site) warnUndeclared() {
+	for _, warning := range site.undeclared() {
+		out("Warning: %s\n", warning)
+	}
+}
+
+// undeclared returns warnings about undeclared dependencies, one for every
+// rule, since pages of the same rule usually read the same pages
+func (site *Site) undeclared() []string {
+	readers := make(map[string]map[string]bool)
+	missing := make(map[string]map[string]bool)
+	for _, page := range site.Pages {
+		if !page.processed || page.Rule == nil || site.Failed(page) {
+			continue
+		}
+
+		for other := range page.discovered {
+			if !other.fromSource || page.Rule.IsDep(other) {
+				continue
+			}
+			if readers[page.Pattern] == nil {
+				readers[page.Pattern] = make(map[string]bool)
+				missing[page.Pattern] = make(map[string]bool)
+			}
+			readers[page.Pattern][page.Source] = true
+			missing[page.Pattern][other.Source] = true
+		}
+	}
+
+	patterns := make([]string, 0, len(readers))
+	for pattern := range readers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	warnings := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		warnings = append(warnings, fmt.Sprintf(
+			"%s uses pages not listed in dependencies of rule '%s': %s",
+			abbreviate(readers[pattern]), pattern, abbreviate(missing[pattern])))
+	}
+	return warnings
+}
+
+// abbreviate joins sorted sources, leaving out all but first three
+func abbreviate(sources map[string]bool) string {
+	list := make([]string, 0, len(sources))
+	for source := range sources {
+		list = append(list, source)
+	}
+	sort.Strings(list)
+	if len(list) > 3 {
+		list = append(list[:3], fmt.Sprintf("and %d more", len(list)-3))
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,112 @@
+package gostatic
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestDiscoverReads(t *testing.T) {
+	a := &Page{Source: "a"}
+	b := &Page{Source: "b", PageHeader: PageHeader{Tags: []string{"x"}}}
+	c := &Page{Source: "c"}
+
+	q := &pageQuery{PageSlice{a, b, c}, a}
+	q.WithTag("x")
+	q.Next(a)
+
+	if !a.queried {
+		t.Error("expected a to be marked as querying pages")
+	}
+	if !a.discovered[b] {
+		t.Error("expected b to be used by a")
+	}
+	if _, ok := a.discovered[c]; ok {
+		t.Error("pages not returned by a query should not be recorded")
+	}
+	if _, ok := a.discovered[a]; ok {
+		t.Error("page should not depend on itself")
+	}
+	if _, ok := a.discovered[nil]; ok {
+		t.Error("missing page should not be recorded")
+	}
+	if b.queried || c.queried || len(c.discovered) > 0 {
+		t.Error("reads should be recorded only for the reading page")
+	}
+}
+
+type testPaginator struct {
+	Pages PageSlice
+}
+
+func (p testPaginator) Prev() *testPaginator { return nil }
+
+func TestBindPageQueries(t *testing.T) {
+	site := &Site{}
+	a := &Page{Site: site, Source: "a"}
+	b := &Page{Site: site, Source: "b"}
+	c := &Page{Site: site, Source: "c", PageHeader: PageHeader{Tags: []string{"x"}}}
+	d := &Page{Site: site, Source: "d"}
+	e := &Page{Site: site, Source: "e"}
+	site.Pages = PageSlice{b, a, c, d, e}
+
+	funcs := template.FuncMap{"pagi": func() testPaginator {
+		return testPaginator{PageSlice{e}}
+	}}
+	tmpl, err := template.New("t").Funcs(TemplateFuncMap).Funcs(funcs).Parse(
+		`{{ range .Site.Pages.WithTag "x" }}{{ .Source }}{{ end }}` +
+			`{{ with $.Site.Pages.BySource "d" }}{{ .Source }}{{ end }}` +
+			`{{ len .Site.Pages }}{{ .Next.Source }}` +
+			`{{ range (pagi).Pages }}{{ .Source }}{{ end }}` +
+			`{{ with (pagi).Prev }}{{ end }}{{ (.Site.Pages.Prev .).Source }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	BindPageQueries(tmpl)
+	BindPageQueries(tmpl) // binding twice changes nothing
+	site.Template = tmpl
+
+	pt, err := a.Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := pt.Execute(&out, a); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "cd5bec" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	for _, other := range []*Page{b, c, d, e} {
+		if !a.discovered[other] {
+			t.Errorf("expected %s to be used by a", other.Source)
+		}
+	}
+	if !a.queried {
+		t.Error("expected a to be marked as querying pages")
+	}
+
+	if err := tmpl.Execute(ioutil.Discard, b); err == nil {
+		t.Error("expected pages to be unavailable outside of Page.Template")
+	}
+}
+
+func TestWarnUndeclared(t *testing.T) {
+	site := &Site{failed: make(map[*Page]bool)}
+	rule := &Rule{Deps: []string{"blog/*.md"}}
+	post := &Page{Source: "blog/post.md", fromSource: true}
+	about := &Page{Source: "about.md", fromSource: true}
+	for _, source := range []string{"a.md", "b.md", "c.md", "d.md", "e.md"} {
+		site.Pages = append(site.Pages, &Page{Site: site, Source: source,
+			Pattern: "*.md", Rule: rule, processed: true,
+			discovered: map[*Page]bool{post: true, about: true}})
+	}
+
+	warnings := site.undeclared()
+	expected := "a.md, b.md, c.md, and 2 more uses pages not listed in " +
+		"dependencies of rule '*.md': about.md"
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Errorf("expected one warning %q, got %q", expected, warnings)
+	}
+}
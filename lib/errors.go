@@ -0,0 +1,100 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BuildError is an error which happened while building a page, with as much
+// context as is known about it.
+type BuildError struct {
+	Source  string
+	Pattern string
+	Command string
+	Line    int
+	Err     error
+}
+
+func (e *BuildError) Error() string {
+	context := make([]string, 0, 3)
+	if e.Pattern != "" {
+		context = append(context, "rule '"+e.Pattern+"'")
+	}
+	if e.Command != "" {
+		context = append(context, "command '"+e.Command+"'")
+	}
+	if e.Line > 0 {
+		context = append(context, fmt.Sprintf("line %d", e.Line))
+	}
+
+	s := e.Source
+	if len(context) > 0 {
+		s += " (" + strings.Join(context, ", ") + ")"
+	}
+	if s == "" {
+		return e.Err.Error()
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// NewBuildError wraps err with information about a page and a command it
+// happened in. Errors which are BuildErrors already are returned untouched.
+func NewBuildError(page *Page, cmd *Command, err error) *BuildError {
+	var be *BuildError
+	if errors.As(err, &be) {
+		return be
+	}
+
+	be = &BuildError{Err: err}
+	if page != nil {
+		be.Source = page.Source
+		be.Pattern = page.Pattern
+	}
+	if cmd != nil {
+		be.Command = string(*cmd)
+	}
+
+	var le *LineError
+	if errors.As(err, &le) {
+		be.Line = le.Line
+	}
+	return be
+}
+
+// LineError is an error which knows line of a source it happened at.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// BuildErrors is a list of errors collected during a build.
+type BuildErrors []*BuildError
+
+func (errs BuildErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("build failed with %d errors:", len(errs)))
+	for _, err := range errs {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+package gostatic
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBuildErrorString(t *testing.T) {
+	err := errors.New("boom")
+	var testTable = []struct {
+		be       BuildError
+		expected string
+	}{
+		{BuildError{Err: err}, "boom"},
+		{BuildError{Source: "a.md", Err: err}, "a.md: boom"},
+		{BuildError{Source: "a.md", Pattern: "*.md", Err: err},
+			"a.md (rule '*.md'): boom"},
+		{BuildError{Source: "a.md", Pattern: "*.md", Command: "config", Line: 3, Err: err},
+			"a.md (rule '*.md', command 'config', line 3): boom"},
+		{BuildError{Source: "a.md", Line: 3, Err: err}, "a.md (line 3): boom"},
+	}
+	for _, s := range testTable {
+		if s.be.Error() != s.expected {
+			t.Errorf("expected %q, got %q", s.expected, s.be.Error())
+		}
+	}
+}
+
+func TestNewBuildError(t *testing.T) {
+	page := &Page{Source: "a.md", Pattern: "*.md"}
+	cmd := Command("config")
+	cause := errors.New("bad date")
+	err := fmt.Errorf("reading config: %w", &LineError{Line: 2, Err: cause})
+
+	be := NewBuildError(page, &cmd, err)
+	if be.Source != "a.md" || be.Pattern != "*.md" || be.Command != "config" ||
+		be.Line != 2 {
+		t.Errorf("unexpected error context %+v", be)
+	}
+	if !errors.Is(be, cause) {
+		t.Error("expected build error to wrap its cause")
+	}
+	if again := NewBuildError(nil, nil, fmt.Errorf("x: %w", be)); again != be {
+		t.Error("expected build error to be returned untouched")
+	}
+}
+
+func TestBuildErrorsString(t *testing.T) {
+	one := &BuildError{Source: "a.md", Err: errors.New("boom")}
+	two := &BuildError{Source: "b.md", Err: errors.New("bang")}
+
+	if s := (BuildErrors{one}).Error(); s != "a.md: boom" {
+		t.Errorf("expected single error as is, got %q", s)
+	}
+	expected := "build failed with 2 errors:\n  a.md: boom\n  b.md: bang"
+	if s := (BuildErrors{one, two}).Error(); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+}
@@ -0,0 +1,103 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Explain writes a description of how page is built: which rule it matched,
+// what commands are going to be run, what it depends on and why it is (or
+// is not) going to be rendered.
+func (site *Site) Explain(w io.Writer, page *Page) {
+	fmt.Fprintf(w, "Source:    %s\n", page.FullPath())
+	fmt.Fprintf(w, "Path:      %s\n", page.Path)
+	fmt.Fprintf(w, "Output:    %s\n", page.OutputPath())
+
+	matches := site.Rules.Matches(page.Source)
+	if len(matches) == 0 {
+		fmt.Fprintf(w, "Rule:      none, file is copied as is\n")
+	} else {
+		fmt.Fprintf(w, "Rule:      %s\n", page.Pattern)
+		for _, m := range matches[1:] {
+			fmt.Fprintf(w, "           overrides %s\n", m.Pattern)
+		}
+		if Ambiguous(matches) {
+			fmt.Fprintf(w, "           %s is equally specific and defined later in config\n",
+				matches[1].Pattern)
+		}
+	}
+
+	if len(page.Captures) > 0 {
+		names := make([]string, 0, len(page.Captures))
+		for name := range page.Captures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "Captures:\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-8s %s\n", name, page.Captures[name])
+		}
+	}
+
+	if page.Rule != nil {
+		fmt.Fprintf(w, "Commands:\n")
+		for _, cmd := range page.Rule.Commands {
+			phase := ""
+			processor, err := cmd.Processor(site)
+			switch {
+			case err != nil:
+				phase = err.Error()
+			case !cmd.Applies(page):
+				phase = "skipped, condition is false"
+			case processor.Mode()&Pre != 0:
+				phase = "pre"
+			default:
+				phase = "render"
+			}
+			fmt.Fprintf(w, "  %-30s (%s)\n", string(cmd), phase)
+		}
+	}
+
+	if page.Rule != nil && len(page.Rule.Deps) > 0 {
+		fmt.Fprintf(w, "Deps:      %s\n", strings.Join(page.Rule.Deps, " "))
+		for _, dep := range page.Deps {
+			fmt.Fprintf(w, "  %s\n", dep.Source)
+		}
+	}
+
+	if deps := site.discovered[page.Path]; deps != nil {
+		fmt.Fprintf(w, "Discovered deps (from previous build):\n")
+		for _, path := range deps.Deps {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+		if deps.Pages != "" {
+			fmt.Fprintf(w, "  list of all pages\n")
+		}
+	}
+
+	if d, err := page.pageDefaults(); err == nil && len(d.files) > 0 {
+		fmt.Fprintf(w, "Defaults:  %s\n", strings.Join(d.files, " "))
+	}
+
+	if templates := page.TemplateFiles(); len(templates) > 0 {
+		fmt.Fprintf(w, "Templates: %s\n", strings.Join(templates, " "))
+	}
+
+	reason := page.ChangeReason()
+	if reason == "" {
+		fmt.Fprintf(w, "Changed:   no, output is up to date\n")
+		return
+	}
+	fmt.Fprintf(w, "Changed:   yes, %s\n", reason)
+	// follow dependencies to the one which really has changed
+	seen := map[*Page]bool{page: true}
+	for dep := page.ChangedBy(); dep != nil && !seen[dep]; dep = dep.ChangedBy() {
+		seen[dep] = true
+		fmt.Fprintf(w, "           %s: %s\n", dep.Source, dep.ChangeReason())
+	}
+}
@@ -0,0 +1,138 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// kinds of graph nodes and edges
+const (
+	NodeSource  = "source"  // page from source directory processed by a rule
+	NodeStatic  = "static"  // page from source directory copied as is
+	NodeVirtual = "virtual" // page created by a processor, like tags
+
+	EdgeDep        = "dep"        // dependency declared in a rule
+	EdgeGenerated  = "generated"  // page was created by processing another one
+	EdgeDiscovered = "discovered" // dependency discovered by previous build
+)
+
+type GraphNode struct {
+	Source  string
+	Path    string
+	Kind    string
+	Pattern string `json:",omitempty"`
+}
+
+// GraphEdge points from a page to one affected by it, i.e. from a dependency
+// to a dependent page.
+type GraphEdge struct {
+	From string
+	To   string
+	Kind string
+}
+
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// Graph returns graph of pages and relationships between them
+func (site *Site) Graph() *Graph {
+	g := &Graph{
+		Nodes: make([]GraphNode, 0, len(site.Pages)),
+		Edges: make([]GraphEdge, 0),
+	}
+
+	for _, page := range site.Pages {
+		kind := NodeSource
+		switch {
+		case !page.fromSource:
+			kind = NodeVirtual
+		case page.Rule == nil:
+			kind = NodeStatic
+		}
+		g.Nodes = append(g.Nodes, GraphNode{page.Source, page.Path, kind, page.Pattern})
+
+		for _, dep := range page.Deps {
+			g.Edges = append(g.Edges, GraphEdge{dep.Source, page.Source, EdgeDep})
+		}
+		for _, origin := range page.Origins {
+			g.Edges = append(g.Edges, GraphEdge{origin.Source, page.Source, EdgeGenerated})
+		}
+		if deps := site.discovered[page.Path]; deps != nil {
+			for _, path := range deps.Deps {
+				// declared dependencies are there already
+				dep := site.Pages.ByPath(path)
+				if dep != nil && !page.Deps.HasPage(func(p *Page) bool { return p == dep }) {
+					g.Edges = append(g.Edges,
+						GraphEdge{dep.Source, page.Source, EdgeDiscovered})
+				}
+			}
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Source < g.Nodes[j].Source
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return g
+}
+
+func (g *Graph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+var (
+	dotNodeStyles = map[string]string{
+		NodeSource:  "shape=box",
+		NodeStatic:  "shape=box, color=gray",
+		NodeVirtual: "shape=box, style=dashed",
+	}
+	dotEdgeStyles = map[string]string{
+		EdgeDep:        "",
+		EdgeGenerated:  " [style=dashed]",
+		EdgeDiscovered: " [style=dotted]",
+	}
+)
+
+// WriteDot writes graph in Graphviz format
+func (g *Graph) WriteDot(w io.Writer) error {
+	lines := []string{"digraph gostatic {", "  rankdir=LR;"}
+	for _, node := range g.Nodes {
+		lines = append(lines, fmt.Sprintf("  %s [%s];",
+			strconv.Quote(node.Source), dotNodeStyles[node.Kind]))
+	}
+	for _, edge := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s%s;",
+			strconv.Quote(edge.From), strconv.Quote(edge.To),
+			dotEdgeStyles[edge.Kind]))
+	}
+	lines = append(lines, "}")
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,12 +4,17 @@
 package gostatic
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
@@ -19,6 +24,9 @@ type PageHeader struct {
 	Date  time.Time
 	Hide  bool
 	Other map[string]string
+	// all values with their types and structure (if format of config has
+	// them), by original keys
+	Params map[string]interface{}
 }
 
 var DATEFORMATS = []string{
@@ -36,26 +44,29 @@ var DATEFORMATS = []string{
 }
 
 func NewPageHeader() *PageHeader {
-	return &PageHeader{Other: make(map[string]string)}
+	return &PageHeader{
+		Other:  make(map[string]string),
+		Params: make(map[string]interface{}),
+	}
 }
 
-func (cfg *PageHeader) ParseLine(line string, s *reflect.Value) {
+func (cfg *PageHeader) ParseLine(line string, s *reflect.Value) error {
 	// Skip empty lines
 	line = strings.TrimSpace(line)
 	if len(line) == 0 {
-		return
+		return nil
 	}
 
 	// Split line in actual name and value
 	bits := TrimSplitN(line, ":", 2)
-	if len(bits) < 2 {
-		errhandle(fmt.Errorf("could not parse '%s' as 'key: value' string",
-			line))
-		return
+	if len(bits) < 2 || len(bits[0]) == 0 {
+		return fmt.Errorf("could not parse '%s' as 'key: value' string",
+			line)
 	}
 
+	cfg.Params[bits[0]] = bits[1]
 	key := strings.ToUpper(bits[0][0:1]) + bits[0][1:]
-	cfg.SetValue(key, bits[1], s)
+	return cfg.SetValue(key, bits[1], s)
 }
 
 var FalsyValues = map[string]bool{
@@ -65,18 +76,18 @@ var FalsyValues = map[string]bool{
 	"f":     true,
 }
 
-func (cfg *PageHeader) SetValue(key string, value string, s *reflect.Value) {
+func (cfg *PageHeader) SetValue(key string, value string, s *reflect.Value) error {
 	// put unknown fields into a map
-	if _, ok := s.Type().FieldByName(key); !ok {
+	if _, ok := s.Type().FieldByName(key); !ok || key == "Params" {
 		cfg.Other[Capitalize(key)] = strings.TrimSpace(value)
-		return
+		return nil
 	}
 
 	// Set value
 	f := s.FieldByName(key)
 	switch typ := f.Interface().(type) {
 	default:
-		errhandle(fmt.Errorf("unknown type of field %s (is type '%v')", key, typ))
+		return fmt.Errorf("unknown type of field %s (is type '%v')", key, typ)
 	case string:
 		f.SetString(value)
 	case bool:
@@ -97,12 +108,15 @@ func (cfg *PageHeader) SetValue(key string, value string, s *reflect.Value) {
 				break
 			}
 		}
-		errhandle(err)
+		if err != nil {
+			return fmt.Errorf("could not parse %s '%s' as a date", key, value)
+		}
 		f.Set(reflect.ValueOf(t))
 	}
+	return nil
 }
 
-func ParseHeader(source string) *PageHeader {
+func ParseHeader(source string) (*PageHeader, error) {
 	cfg := NewPageHeader()
 
 	s := reflect.ValueOf(cfg).Elem()
@@ -116,14 +130,53 @@ func ParseHeader(source string) *PageHeader {
 		}
 	}
 
-	for _, line := range strings.Split(source, "\n") {
-		cfg.ParseLine(line, &s)
+	for i, line := range strings.Split(source, "\n") {
+		err := cfg.ParseLine(line, &s)
+		if err != nil {
+			return nil, &LineError{Line: i + 1, Err: err}
+		}
+	}
+
+	return cfg, nil
+}
+
+func ParseYamlHeader(source string) (*PageHeader, error) {
+	m := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(source), &m)
+	if err != nil {
+		return nil, err
+	}
+	return NewPageHeaderFromMap(m)
+}
+
+func ParseTomlHeader(source string) (*PageHeader, error) {
+	m := make(map[string]interface{})
+	_, err := toml.Decode(source, &m)
+	if err != nil {
+		return nil, err
 	}
+	return NewPageHeaderFromMap(m)
+}
 
-	return cfg
+func ParseJsonHeader(source string) (*PageHeader, error) {
+	m := make(map[string]interface{})
+	// numbers are decoded as json.Number, so that big integers are not
+	// turned into floats, see normalizeParam
+	dec := json.NewDecoder(strings.NewReader(source))
+	dec.UseNumber()
+	err := dec.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return nil, errors.New("invalid data after top-level json object")
+	}
+	return NewPageHeaderFromMap(m)
 }
 
-func ParseYamlHeader(source string) *PageHeader {
+// NewPageHeaderFromMap makes a header from config decoded from structured
+// format, like YAML, TOML or JSON
+func NewPageHeaderFromMap(m map[string]interface{}) (*PageHeader, error) {
 	cfg := NewPageHeader()
 
 	s := reflect.ValueOf(cfg).Elem()
@@ -137,26 +190,127 @@ func ParseYamlHeader(source string) *PageHeader {
 		}
 	}
 
-	m := make(map[string]interface{})
-	yaml.Unmarshal([]byte(source), &m)
-
-	for key, value := range m {
-		key := strings.ToUpper(key[0:1]) + key[1:]
-		switch value.(type) {
-		default:
-			cfg.SetValue(key, value.(string), &s)
-		case []interface{}:
-			temp := make([]string, len(value.([]interface{})))
-			for i, v := range value.([]interface{}) {
-				temp[i] = fmt.Sprint(v)
+	err := cfg.SetMap(m, &s)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetMap sets values decoded from structured config (like YAML): known
+// fields and Other get their string representation, while Params keeps them
+// as they are
+func (cfg *PageHeader) SetMap(m map[string]interface{}, s *reflect.Value) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := normalizeParam(m[key])
+		if value == nil || key == "" {
+			continue
+		}
+		cfg.Params[key] = value
+
+		field := strings.ToUpper(key[0:1]) + key[1:]
+		flat, ok := flatValue(value)
+		if !ok {
+			// structures are accessible only through Params
+			if _, known := s.Type().FieldByName(field); known && field != "Params" {
+				return fmt.Errorf("%s cannot be a map", key)
 			}
-			cfg.SetValue(key, strings.Join(temp, ","), &s)
-		case bool:
-			cfg.SetValue(key, strconv.FormatBool(value.(bool)), &s)
-		case nil:
 			continue
 		}
+		if err := cfg.SetValue(field, flat, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeParam converts maps with non-string keys (YAML has those) to
+// map[string]interface{} and lists of maps (TOML has those) to
+// []interface{}, so that they can be used in templates and JSON; JSON
+// numbers become int64 or float64
+func normalizeParam(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, x := range v {
+			list[i] = normalizeParam(x)
+		}
+		return list
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[fmt.Sprint(key)] = normalizeParam(x)
+		}
+		return m
+	case map[string]interface{}:
+		for key, x := range v {
+			v[key] = normalizeParam(x)
+		}
+		return v
+	case []interface{}:
+		for i, x := range v {
+			v[i] = normalizeParam(x)
+		}
+		return v
+	}
+	return value
+}
+
+// flatValue returns string representation of a value, lists are joined with
+// commas; maps have none
+func flatValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	case map[string]interface{}:
+		return "", false
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := flatValue(x); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ","), true
 	}
+	return fmt.Sprint(value), true
+}
 
-	return cfg
+// LookupParam returns values found by a dotted path (`hero.image') in
+// params, going into every element of lists on the way
+func LookupParam(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if x, ok := v[path[0]]; ok {
+			return LookupParam(x, path[1:])
+		}
+	case []interface{}:
+		found := make([]interface{}, 0)
+		for _, x := range v {
+			found = append(found, LookupParam(x, path)...)
+		}
+		return found
+	}
+	return nil
 }
@@ -0,0 +1,80 @@
+package gostatic
+
+import (
+	"testing"
+)
+
+func TestParseYamlHeaderParams(t *testing.T) {
+	header, err := ParseYamlHeader(`title: Typed
+weight: 10
+draft: false
+tags: [a, b]
+hero:
+  image: hero.png
+  alt: Hero
+authors:
+  - name: Alice
+    url: http://alice.example
+  - name: Bob
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Title != "Typed" || len(header.Tags) != 2 {
+		t.Errorf("unexpected header %+v", header)
+	}
+	if header.Params["weight"] != 10 || header.Other["Weight"] != "10" {
+		t.Errorf("expected weight to be 10, got %#v and %q",
+			header.Params["weight"], header.Other["Weight"])
+	}
+	if header.Other["Draft"] != "false" {
+		t.Errorf("expected draft to be 'false', got %q", header.Other["Draft"])
+	}
+	if _, ok := header.Other["Hero"]; ok {
+		t.Error("maps should be only in Params")
+	}
+
+	page := &Page{PageHeader: *header}
+	var testTable = []struct {
+		field   string
+		value   string
+		matches bool
+	}{
+		{"Params.hero.image", "hero.png", true},
+		{"Params.hero.alt", "Villain", false},
+		{"Params.authors.name", "Bob", true},
+		{"Params.tags", "b", true},
+		{"Params.weight", "10", true},
+		{"Params.missing.key", "", false},
+	}
+	for _, s := range testTable {
+		if page.Has(s.field, s.value) != s.matches {
+			t.Errorf("Has(%s, %s): expected %v", s.field, s.value, s.matches)
+		}
+	}
+
+	if _, err := ParseYamlHeader("title: {a: b}\n"); err == nil {
+		t.Error("expected error for map in title")
+	}
+}
+
+func TestParseJsonHeaderNumbers(t *testing.T) {
+	header, err := ParseJsonHeader(`{"title": "Numbers", "weight": 1000000, "ratio": 1.5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Params["weight"] != int64(1000000) || header.Other["Weight"] != "1000000" {
+		t.Errorf("expected weight to be 1000000, got %#v and %q",
+			header.Params["weight"], header.Other["Weight"])
+	}
+	if header.Params["ratio"] != 1.5 || header.Other["Ratio"] != "1.5" {
+		t.Errorf("expected ratio to be 1.5, got %#v and %q",
+			header.Params["ratio"], header.Other["Ratio"])
+	}
+
+	if _, err := ParseJsonHeader(`{"title": "x"} {}`); err == nil {
+		t.Error("expected error for data after json object")
+	}
+}
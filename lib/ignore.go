@@ -0,0 +1,124 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// IgnoreName is a file in source directory which lists (like .gitignore)
+// files not being part of a site
+const IgnoreName = ".gostaticignore"
+
+type ignorePattern struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore matches paths relative to source directory against a list of
+// patterns with .gitignore semantics: patterns without a slash match names
+// at any level, `!' re-includes files, trailing `/' matches only
+// directories, and files in ignored directories are ignored.
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+// sourceFilter decides which files in source directory are part of a site
+type sourceFilter struct {
+	source   string
+	ignore   *Ignore
+	dotfiles *Ignore // dotfiles which are not skipped
+}
+
+// excluded tells if path relative to source directory is ignored or is a
+// dotfile not listed in INCLUDE_DOTFILES
+func (f *sourceFilter) excluded(relpath string, isDir bool) bool {
+	if relpath == "." {
+		return false
+	}
+	if f.ignore.Match(relpath, isDir) {
+		return true
+	}
+	return !isDir && strings.HasPrefix(path.Base(relpath), ".") &&
+		!f.dotfiles.Match(relpath, false)
+}
+
+// NewIgnore parses patterns, skipping empty ones and comments
+func NewIgnore(lines []string) *Ignore {
+	ig := &Ignore{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		if line == "" || !doublestar.ValidatePattern(line) {
+			continue
+		}
+		p.glob = line
+		ig.patterns = append(ig.patterns, p)
+	}
+	return ig
+}
+
+// ReadIgnore reads patterns from IgnoreName file in dir (if there is one)
+// and adds extra ones after them
+func ReadIgnore(dir string, extra []string) (*Ignore, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IgnoreName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	lines := append(strings.Split(string(data), "\n"), extra...)
+	return NewIgnore(lines), nil
+}
+
+// Match tells if path (with forward slashes) is ignored
+func (ig *Ignore) Match(path string, isDir bool) bool {
+	if ig == nil || len(ig.patterns) == 0 {
+		return false
+	}
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.match(path, isDir)
+}
+
+func (ig *Ignore) match(path string, isDir bool) bool {
+	ignored := false
+	for _, p := range ig.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matched, _ := doublestar.Match(p.glob, path); matched {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,135 @@
+package gostatic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnore(t *testing.T) {
+	ig := NewIgnore([]string{
+		"# comment",
+		"*.psd",
+		"node_modules/",
+		"/drafts",
+		"*~",
+		"blog/*.txt",
+		"!blog/keep.txt",
+	})
+
+	var testTable = []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"logo.psd", false, true},
+		{"img/logo.psd", false, true},
+		{"node_modules", true, true},
+		{"js/node_modules/x/index.js", false, true},
+		{"node_modules", false, false},
+		{"drafts/post.md", false, true},
+		{"blog/drafts/post.md", false, false},
+		{"index.md~", false, true},
+		{"blog/notes.txt", false, true},
+		{"blog/keep.txt", false, false},
+		{"blog/index.md", false, false},
+	}
+
+	for _, s := range testTable {
+		if ig.Match(s.path, s.isDir) != s.ignored {
+			t.Errorf("%s: expected ignored to be %v", s.path, s.ignored)
+		}
+	}
+}
+
+func TestIsDepNegative(t *testing.T) {
+	rule := &Rule{Deps: []string{"blog/**/*.md", "!blog/drafts/**"}}
+	if !rule.IsDep(&Page{Source: "blog/one.md"}) {
+		t.Error("blog/one.md should be a dependency")
+	}
+	if rule.IsDep(&Page{Source: "blog/drafts/two.md"}) {
+		t.Error("blog/drafts/two.md should be excluded")
+	}
+}
+
+func TestSourceFilterDotfiles(t *testing.T) {
+	filter := &sourceFilter{
+		ignore:   NewIgnore([]string{"*.psd"}),
+		dotfiles: NewIgnore([]string{".well-known/**", ".htaccess"}),
+	}
+
+	var testTable = []struct {
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{".htaccess", false, false},
+		{"sub/.htaccess", false, false},
+		{".well-known", true, false},
+		{".well-known/security.txt", false, false},
+		{".well-known/.hidden", false, false},
+		{".DS_Store", false, true},
+		{"blog/.draft.md", false, true},
+		{"logo.psd", false, true},
+		{"index.md", false, false},
+	}
+	for _, s := range testTable {
+		if filter.excluded(s.path, s.isDir) != s.excluded {
+			t.Errorf("%s: expected excluded to be %v", s.path, s.excluded)
+		}
+	}
+}
+
+func TestSkippedMatchesCollect(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"config", "t.tmpl",
+		"src/index.html", "src/.htaccess", "src/.DS_Store", "src/logo.psd",
+		"src/.well-known/security.txt", "src/.well-known/.hidden",
+		"src/blog/.draft.md", "src/blog/post.html",
+	}
+	for _, name := range files {
+		content := ""
+		if name == "config" {
+			content = "TEMPLATES = t.tmpl\nSOURCE = src\nOUTPUT = out\n" +
+				"IGNORE = *.psd\nINCLUDE_DOTFILES = .well-known/** .htaccess\n"
+		}
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site := NewSite(filepath.Join(dir, "config"), ProcessorMap{})
+	if err := site.Reconfig(); err != nil {
+		t.Fatal(err)
+	}
+	collected := make(map[string]bool)
+	for _, page := range site.Pages {
+		collected[page.Source] = true
+	}
+	expected := []string{".htaccess", ".well-known/.hidden",
+		".well-known/security.txt", "blog/post.html", "index.html"}
+	if len(collected) != len(expected) {
+		t.Errorf("expected %v to be collected, got %v", expected, collected)
+	}
+	for _, source := range expected {
+		if !collected[source] {
+			t.Errorf("expected %s to be collected", source)
+		}
+	}
+
+	for _, name := range files {
+		if !strings.HasPrefix(name, "src/") {
+			continue
+		}
+		source := strings.TrimPrefix(name, "src/")
+		if site.Skipped(filepath.Join(site.Source, source)) == collected[source] {
+			t.Errorf("%s: watcher and collector disagree, collected is %v",
+				source, collected[source])
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Macro is a named list of commands, defined with `define NAME [PARAMS]:'
+// and used in rules like a processor.
+type Macro struct {
+	Params   []string
+	Defaults map[string]string `json:",omitempty"`
+	Commands []string
+}
+
+var paramRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseDefine parses `define NAME [PARAMS]:' line, parameters are names with
+// optional default values: `define article layout=page:'
+func (cfg *SiteConfig) ParseDefine(line string) (*Macro, error) {
+	bits := TrimSplitN(line[len("define "):], ":", 2)
+	if len(bits) != 2 || bits[1] != "" {
+		return nil, fmt.Errorf("cannot parse '%s', 'define NAME [PARAMS]:' expected", line)
+	}
+	words := strings.Fields(bits[0])
+	if len(words) == 0 {
+		return nil, errors.New("macro name is missing")
+	}
+
+	macro := &Macro{
+		Params:   make([]string, 0),
+		Defaults: make(map[string]string),
+		Commands: make([]string, 0),
+	}
+	for _, word := range words[1:] {
+		param := TrimSplitN(word, "=", 2)
+		if !paramRe.MatchString(param[0]) {
+			return nil, fmt.Errorf("invalid macro parameter '%s'", param[0])
+		}
+		macro.Params = append(macro.Params, param[0])
+		if len(param) == 2 {
+			macro.Defaults[param[0]] = param[1]
+		}
+	}
+
+	if cfg.Macros == nil {
+		cfg.Macros = make(map[string]*Macro)
+	}
+	cfg.Macros[words[0]] = macro
+	return macro, nil
+}
+
+func (macro *Macro) ParseCommand(line string) error {
+	if _, err := SplitCommand(line); err != nil {
+		return fmt.Errorf("%v: %s", err, line)
+	}
+	macro.Commands = append(macro.Commands, line)
+	return nil
+}
+
+// Expand returns commands of a macro with `$(param)' replaced by arguments
+func (macro *Macro) Expand(cfg *SiteConfig, name string, args []string) (CommandList, error) {
+	if len(args) > len(macro.Params) {
+		return nil, fmt.Errorf("macro '%s' takes %d arguments, %d given",
+			name, len(macro.Params), len(args))
+	}
+	values := make(map[string]string, len(macro.Params))
+	for i, param := range macro.Params {
+		value, ok := macro.Defaults[param]
+		if i < len(args) {
+			value, ok = args[i], true
+		}
+		if !ok {
+			return nil, fmt.Errorf("macro '%s': missing value for '%s'", name, param)
+		}
+		values[param] = QuoteArg(value)
+	}
+
+	commands := make(CommandList, 0, len(macro.Commands))
+	for _, line := range macro.Commands {
+		line = VarRe.ReplaceAllStringFunc(line, func(m string) string {
+			if value, ok := values[VarRe.FindStringSubmatch(m)[1]]; ok {
+				return value
+			}
+			return m
+		})
+		commands = append(commands, Command(cfg.SubVars(line)))
+	}
+	return commands, nil
+}
+
+// expandMacros replaces usages of macros in rules with their commands,
+// errors point to the line macro is used at
+func (cfg *SiteConfig) expandMacros() error {
+	if len(cfg.Macros) == 0 {
+		return nil
+	}
+	for pattern, rules := range cfg.Rules {
+		for _, rule := range rules {
+			commands := make(CommandList, 0, len(rule.Commands))
+			lines := make([]configPos, 0, len(rule.lines))
+			for i, cmd := range rule.Commands {
+				expanded, err := cfg.expand(CommandList{cmd}, nil)
+				if err != nil {
+					pos := rule.lines[i]
+					return &ConfigError{pos.file, pos.line,
+						fmt.Errorf("rule '%s': %v", pattern, err)}
+				}
+				commands = append(commands, expanded...)
+				for range expanded {
+					lines = append(lines, rule.lines[i])
+				}
+			}
+			rule.Commands, rule.lines = commands, lines
+		}
+	}
+	return nil
+}
+
+func (cfg *SiteConfig) expand(commands CommandList, stack []string) (CommandList, error) {
+	result := make(CommandList, 0, len(commands))
+	for _, cmd := range commands {
+		name := cmd.Name()
+		macro := cfg.Macros[name]
+		if macro == nil {
+			result = append(result, cmd)
+			continue
+		}
+		for _, used := range stack {
+			if used == name {
+				return nil, fmt.Errorf("macro '%s' is used recursively", name)
+			}
+		}
+
+		expanded, err := macro.Expand(cfg, name, cmd.Args())
+		if err != nil {
+			return nil, err
+		}
+		expanded, err = cfg.expand(expanded, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		// condition of usage applies to every command of a macro
+		guard := cmd.Guard()
+		for _, c := range expanded {
+			result = append(result, Command(guard+string(c)))
+		}
+	}
+	return result, nil
+}
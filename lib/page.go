@@ -5,6 +5,7 @@ package gostatic
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,7 +13,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 	"github.com/bmatcuk/doublestar/v4"
 )
@@ -30,34 +34,67 @@ type Page struct {
 	Site    *Site `json:"-"`
 	Rule    *Rule
 	Pattern string
-	Deps    PageSlice `json:"-"`
+	// groups captured by regular expression rule, by number and by name
+	Captures map[string]string `json:",omitempty"`
+	Deps     PageSlice         `json:"-"`
 
 	Source  string
 	Path    string
 	ModTime time.Time
+	// pages which made processors (like tags) create this one
+	Origins PageSlice `json:"-"`
 
 	processed bool
 	state     int
 	raw       string
 	content   string
 	wasread   bool // if content was read already
+	reason    string // why page is changed
+	changedBy *Page  // dependency which made page changed
+
+	// pages read while processing, see discover.go; fromSource is false for
+	// pages created by processors
+	discovered map[*Page]bool
+	queried    bool
+	fromSource bool
+
+	// done is closed when processing is finished, waitsFor is a page which
+	// has to be processed before this one can continue; both and processed
+	// are guarded by Site.procMx, see Page.Process
+	done     chan struct{}
+	waitsFor *Page
+
+	// mx guards raw, content and wasread
+	mx sync.Mutex
 }
 
 type PageSlice []*Page
 
-func NewPages(site *Site, path string) PageSlice {
+func NewPages(site *Site, path string) (PageSlice, error) {
 	stat, err := os.Stat(path)
-	errhandle(err)
+	if err != nil {
+		return nil, err
+	}
 
 	relpath, err := filepath.Rel(site.Source, path)
-	errhandle(err)
+	if err != nil {
+		return nil, err
+	}
 
 	// convert windows path separators to unix style
 	relpath = strings.Replace(relpath, "\\", "/", -1)
 
-	pattern, rules := site.Rules.MatchedRules(relpath)
-	if rules == nil {
-		rules = make([]*Rule, 1)
+	pattern, rules := "", make([]*Rule, 1)
+	matches := site.Rules.Matches(relpath)
+	if len(matches) > 0 {
+		pattern, rules = matches[0].Pattern, matches[0].Rules
+	}
+	// only ties are worth a warning, other choices are visible with -v
+	// and --show-rules
+	if Ambiguous(matches) {
+		out("Warning: %s\n", RuleChoice(relpath, matches))
+	} else if len(matches) > 1 {
+		debug("%s\n", RuleChoice(relpath, matches))
 	}
 
 	pages := make(PageSlice, 0)
@@ -70,19 +107,37 @@ func NewPages(site *Site, path string) PageSlice {
 			Source:  relpath,
 			Path:    relpath,
 			ModTime: stat.ModTime(),
+
+			Captures: rule.Captures(relpath),
+
+			fromSource: true,
+		}
+		// pages without config still get defaults
+		if err := page.SetHeader(NewPageHeader()); err != nil {
+			site.addError(page, err)
+		}
+		if err := page.Peek(); err != nil {
+			site.addError(page, err)
 		}
-		page.Peek()
 		debug("Found page: %s; rule: %v\n",
 			page.Source, page.Rule)
 		pages = append(pages, page)
 	}
-	return pages
+	return pages, nil
 }
 
 func (page *Page) Raw() string {
+	page.mx.Lock()
+	defer page.mx.Unlock()
+	return page.readRaw()
+}
+
+func (page *Page) readRaw() string {
 	if !page.wasread {
 		data, err := ioutil.ReadFile(page.FullPath())
-		errhandle(err)
+		if err != nil {
+			page.Site.addError(page, err)
+		}
 
 		// remove BOM if present
 		if bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}) {
@@ -97,14 +152,24 @@ func (page *Page) Raw() string {
 }
 
 func (page *Page) Content() string {
+	page.mx.Lock()
+	defer page.mx.Unlock()
 	if page.content == "" {
-		return page.Raw()
+		return page.readRaw()
 	}
 	return page.content
 }
 
 func (page *Page) SetContent(content string) {
+	page.mx.Lock()
 	page.content = content
+	page.mx.Unlock()
+}
+
+func (page *Page) AddOrigin(origin *Page) {
+	page.mx.Lock()
+	page.Origins = append(page.Origins, origin)
+	page.mx.Unlock()
 }
 
 func (page *Page) SetState(state int) {
@@ -121,7 +186,8 @@ func (page *Page) OutputPath() string {
 
 func (page *Page) Url() string {
 	if page == nil {
-		errexit(fmt.Errorf(".Url called on a Page which does not exist"))
+		// templates turn panics in method calls into errors
+		panic(errors.New(".Url called on a Page which does not exist"))
 	}
 	url := strings.Replace(page.Path, string(filepath.Separator), "/", -1)
 	if url == "index.html" {
@@ -161,10 +227,14 @@ func (page *Page) Is(path string) bool {
 
 // SetWasRead is used for dynamically created pages
 func (page *Page) SetWasRead(wasread bool) {
+	page.mx.Lock()
 	page.wasread = wasread
+	page.mx.Unlock()
 }
 
 func (page *Page) WasRead() bool {
+	page.mx.Lock()
+	defer page.mx.Unlock()
 	return page.wasread
 }
 
@@ -184,12 +254,36 @@ func (page *Page) Peek() error {
 	}
 
 	// Raw is page content after preprocessors, but before preprocessors
+	page.mx.Lock()
 	if page.content != "" {
 		page.raw = page.content
 	}
+	page.mx.Unlock()
 	return nil
 }
 
+// Template returns site templates with functions bound to the page, so that
+// state of functions like `changed' does not leak between pages rendered in
+// parallel, and pages read by templates are recorded as page's dependencies
+func (page *Page) Template() (*template.Template, error) {
+	t, err := page.Site.Template.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.Funcs(template.FuncMap{
+		"changed": func(name string, value interface{}) bool {
+			return HasChanged(page.Path+"\x00"+name, value)
+		},
+		"pages": func() *pageQuery {
+			return &pageQuery{page.Site.Pages, page}
+		},
+		"readPage": page.readPage,
+		"version": func(current *Page, value string) (string, error) {
+			return versionize(page, current, value)
+		},
+	}), nil
+}
+
 func (page *Page) findDeps() {
 	if page.Rule == nil {
 		return
@@ -210,31 +304,124 @@ func (page *Page) Changed() bool {
 	}
 
 	if page.state == StateUnknown {
+		// set before looking at dependencies to break cycles
 		page.state = StateUnchanged
-		dest, err := os.Stat(page.OutputPath())
-
-		if err != nil ||
-			dest.ModTime().Before(page.ModTime) ||
-			dest.ModTime().Before(page.Site.ChangedAt) {
+		page.reason = page.changeReason()
+		if page.reason != "" {
 			page.state = StateChanged
-		} else {
-			for _, dep := range page.Deps {
-				if dep.Changed() {
-					page.state = StateChanged
-				}
-			}
 		}
 	}
 
 	return page.state == StateChanged
 }
 
+// ChangeReason tells why page is going to be rendered, or returns empty
+// string if it's up to date
+func (page *Page) ChangeReason() string {
+	if page.Site.ForceRefresh {
+		return "forced rebuild of all pages"
+	}
+	page.Changed()
+	return page.reason
+}
+
+// ChangedBy returns dependency (declared or discovered), which made page
+// changed, if any
+func (page *Page) ChangedBy() *Page {
+	page.Changed()
+	return page.changedBy
+}
+
+// changeReason tells why page has to be rebuilt, or returns empty string
+func (page *Page) changeReason() string {
+	if page.Site.db != nil {
+		if reason := page.Site.db.changeReason(page); reason != "" {
+			return reason
+		}
+	} else {
+		dest, err := os.Stat(page.OutputPath())
+		switch {
+		case err != nil:
+			return "output does not exist"
+		case dest.ModTime().Before(page.ModTime):
+			return "source is newer than output"
+		case dest.ModTime().Before(page.Site.ChangedAt):
+			return "config is newer than output"
+		}
+		// virtual pages don't read config, so don't get defaults
+		if d, err := page.pageDefaults(); err == nil && page.Rule != nil &&
+			page.fromSource && dest.ModTime().Before(d.modTime) {
+			return "defaults " + strings.Join(d.files, " ") + " are newer than output"
+		}
+		for _, fn := range page.TemplateFiles() {
+			if dest.ModTime().Before(page.Site.templateTimes[fn]) {
+				return "template " + fn + " is newer than output"
+			}
+		}
+	}
+
+	for _, dep := range page.Deps {
+		if dep.Changed() {
+			page.changedBy = dep
+			return "dependency " + dep.Source + " changed"
+		}
+	}
+	return page.discoveredReason()
+}
+
 func (page *Page) Process() (*Page, error) {
-	if page.processed || page.Rule == nil {
+	return page.processFor(nil)
+}
+
+// processFor processes page needed by reader (page being processed itself,
+// or nil). If page is being processed already, it's waited for, unless that
+// waits for reader - i.e. page uses `version' on itself, or pages use each
+// other - then page is returned as it is to break the cycle.
+func (page *Page) processFor(reader *Page) (*Page, error) {
+	reader.discover(page)
+	if page.Rule == nil {
+		return page, nil
+	}
+
+	site := page.Site
+	site.procMx.Lock()
+	if page.processed {
+		for p := page; p != nil; p = p.waitsFor {
+			if p == reader {
+				site.procMx.Unlock()
+				return page, nil
+			}
+		}
+		done := page.done
+		if reader != nil {
+			reader.waitsFor = page
+		}
+		site.procMx.Unlock()
+
+		<-done
+		if reader != nil {
+			site.procMx.Lock()
+			reader.waitsFor = nil
+			site.procMx.Unlock()
+		}
 		return page, nil
 	}
 
 	page.processed = true
+	page.done = make(chan struct{})
+	if reader != nil {
+		reader.waitsFor = page
+	}
+	site.procMx.Unlock()
+	defer func() {
+		site.procMx.Lock()
+		if reader != nil {
+			reader.waitsFor = nil
+		}
+		close(page.done)
+		site.procMx.Unlock()
+	}()
+
 	if page.Rule.Commands != nil {
 		for _, cmd := range page.Rule.Commands {
 			err := page.Site.ProcessCommand(page, &cmd, false)
@@ -252,8 +439,9 @@ func (page *Page) WriteTo(writer io.Writer) (n int64, err error) {
 		return 0, nil
 	}
 
-	if !page.processed {
-		page.Process()
+	_, err = page.Process()
+	if err != nil {
+		return 0, err
 	}
 
 	nint, err := writer.Write([]byte(page.Content()))
@@ -274,12 +462,40 @@ func (page *Page) Render() (n int64, err error) {
 	return page.WriteTo(file)
 }
 
-func (page *Page) UrlMatches(regex string) bool {
+func (page *Page) UrlMatches(regex string) (bool, error) {
 	re, err := regexp.Compile(regex)
 	if err != nil {
-		errhandle(fmt.Errorf("Incorrect regex given to Page.UrlMatches: '%s' ", regex))
+		return false, fmt.Errorf("incorrect regex given to Page.UrlMatches: '%s'", regex)
+	}
+	return re.Match([]byte(page.Url())), nil
+}
+
+// Field returns value of a page field by name, fields unknown to gostatic are
+// looked up in page config (.Other)
+func (page *Page) Field(name string) string {
+	switch Capitalize(name) {
+	case "Title":
+		return page.Title
+	case "Source":
+		return page.Source
+	case "Path":
+		return page.Path
+	case "Url":
+		return page.Url()
+	case "Name":
+		return page.Name()
+	case "Date":
+		if page.Date.IsZero() {
+			return ""
+		}
+		return page.Date.Format("2006-01-02")
+	case "Tags":
+		return strings.Join(page.Tags, ",")
+	case "Hide":
+		return strconv.FormatBool(page.Hide)
+	default:
+		return page.Other[Capitalize(name)]
 	}
-	return re.Match([]byte(page.Url()))
 }
 
 func (page *Page) Has(field, value string) bool {
@@ -287,13 +503,33 @@ func (page *Page) Has(field, value string) bool {
 	case "Title": return page.Title == value
 	case "Tag": return (page.Tags != nil &&
 		SliceStringIndexOf(page.Tags, value) != -1)
-	case "Url": return page.UrlMatches(value)
+	case "Url": matched, _ := page.UrlMatches(value)
+		return matched
 	case "Source": matched, _ := doublestar.Match(value, page.Source)
 		return matched
 	case "Hide": return ((page.Hide == true && value == "true") ||
 		(page.Hide == false && value == "false"))
-	default: return page.Other[field] == value
 	}
+	if strings.HasPrefix(field, "Other.") {
+		return page.Other[Capitalize(field[len("Other."):])] == value
+	}
+	if strings.HasPrefix(field, "Params.") {
+		path := strings.Split(field[len("Params."):], ".")
+		for _, found := range LookupParam(page.Params, path) {
+			// lists match if any of elements matches
+			items, ok := found.([]interface{})
+			if !ok {
+				items = []interface{}{found}
+			}
+			for _, x := range items {
+				if s, ok := flatValue(x); ok && s == value {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return page.Other[field] == value
 }
 
 func (page *Page) Prev() *Page {
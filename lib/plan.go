@@ -0,0 +1,57 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"sort"
+)
+
+// actions in a build plan
+const (
+	ActionWrite  = "write"  // page is processed by a rule and written
+	ActionCopy   = "copy"   // page has no rule and is copied as is
+	ActionDelete = "delete" // file is stale and removed from output
+)
+
+// PlanEntry is something build is going to do with a file in output
+// directory.
+type PlanEntry struct {
+	Action string
+	Path   string // relative to output directory
+	Source string `json:",omitempty"`
+	Reason string
+}
+
+// Plan returns what Render would do with output directory, without
+// processing or writing anything
+func (site *Site) Plan() ([]PlanEntry, error) {
+	plan := make([]PlanEntry, 0)
+	for _, page := range site.Pages {
+		reason := page.ChangeReason()
+		if reason == "" || site.Failed(page) {
+			continue
+		}
+		action := ActionWrite
+		if page.Rule == nil {
+			action = ActionCopy
+		}
+		plan = append(plan, PlanEntry{action, page.Path, page.Source, reason})
+	}
+
+	if !site.keepStale() {
+		stale, err := site.StaleOutputs()
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range stale {
+			plan = append(plan, PlanEntry{ActionDelete, path, "",
+				"not produced by any page"})
+		}
+	}
+
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Path < plan[j].Path
+	})
+	return plan, nil
+}
@@ -2,6 +2,7 @@ package gostatic
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 )
@@ -42,24 +43,115 @@ func (pm ProcessorMap) ProcessorSummary() {
 	}
 }
 
-func (cmd *Command) Name() string {
+// GuardRe matches condition of a command: `if Field=Value: command' or
+// `unless Field=Value: command'
+var GuardRe = regexp.MustCompile(`^(if|unless)\s+([^=\s]+)=(.*?):\s+(.*)$`)
+
+// IsGuarded tells if command looks like it has a condition
+func (cmd *Command) IsGuarded() bool {
 	c := string(*cmd)
+	return strings.HasPrefix(c, "if ") || strings.HasPrefix(c, "unless ")
+}
+
+// Body returns command without its conditions
+func (cmd *Command) Body() Command {
+	if !cmd.IsGuarded() {
+		return *cmd
+	}
+	m := GuardRe.FindStringSubmatch(string(*cmd))
+	if m == nil {
+		return ""
+	}
+	body := Command(m[4])
+	return body.Body()
+}
+
+// Guard returns conditions of a command, including trailing space
+func (cmd *Command) Guard() string {
+	return strings.TrimSuffix(string(*cmd), string(cmd.Body()))
+}
+
+// Applies tells if command should be run for a page, conditions are checked
+// with Page.Has
+func (cmd *Command) Applies(page *Page) bool {
+	if !cmd.IsGuarded() {
+		return true
+	}
+	m := GuardRe.FindStringSubmatch(string(*cmd))
+	if m == nil {
+		return false
+	}
+	body := Command(m[4])
+	return page.Has(m[2], m[3]) == (m[1] == "if") && body.Applies(page)
+}
+
+func (cmd *Command) Name() string {
+	c := string(cmd.Body())
 	if strings.HasPrefix(c, ":") {
 		return "external"
-	} else {
-		return strings.SplitN(c, " ", 2)[0]
 	}
+	words, _ := SplitCommand(c)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[0]
 }
 
+// Args returns arguments of a command, split like shell does (see
+// SplitCommand); quoting is checked when config is read
 func (cmd *Command) Args() []string {
-	c := string(*cmd)
+	c := string(cmd.Body())
 	if strings.HasPrefix(c, ":") {
-		return strings.Split(c[1:], " ")
-	} else {
-		return strings.Split(c, " ")[1:]
+		words, _ := SplitCommand(c[1:])
+		return words
 	}
+	words, _ := SplitCommand(c)
+	if len(words) == 0 {
+		return nil
+	}
+	return words[1:]
 }
 
+// PageVarRe matches $(page.Field) and $(page.Field|default) in commands
+var PageVarRe = regexp.MustCompile(`\$\(page\.([A-Za-z0-9_]+)(\|[^\)]*)?\)`)
+
+// CaptureRe matches $1 and ${name} references to groups captured by
+// regular expression rule
+var CaptureRe = regexp.MustCompile(`\$([0-9]+|\{[A-Za-z0-9_]+\})`)
+
+// PageArgs returns arguments of a command with captured groups (see
+// Rule.Captures) and page fields (see Page.Field) substituted, a field which
+// is empty and has no default is an error. Groups are not substituted in
+// external commands, where `$1' and `${NAME}' belong to shell or a program.
+func (cmd *Command) PageArgs(page *Page) ([]string, error) {
+	var err error
+	args := cmd.Args()
+	captures := page.Captures != nil && cmd.Name() != "external"
+	for i, arg := range args {
+		if captures {
+			arg = CaptureRe.ReplaceAllStringFunc(arg, func(m string) string {
+				name := strings.Trim(m[1:], "{}")
+				value, ok := page.Captures[name]
+				if !ok && err == nil {
+					err = fmt.Errorf("group '%s' is not captured by rule pattern", name)
+				}
+				return value
+			})
+		}
+		args[i] = PageVarRe.ReplaceAllStringFunc(arg, func(m string) string {
+			sub := PageVarRe.FindStringSubmatch(m)
+			value := page.Field(sub[1])
+			if value != "" {
+				return value
+			}
+			if sub[2] == "" && err == nil {
+				err = fmt.Errorf("page field '%s' is empty and has no default", sub[1])
+			}
+			return strings.TrimPrefix(sub[2], "|")
+		})
+	}
+	return args, err
+}
 
 func (cmd *Command) Processor(s *Site) (Processor, error) {
 	name := cmd.Name()
@@ -82,10 +174,18 @@ func (cmd *Command) IsPre(s *Site) (bool, error) {
 func (s *Site) ProcessCommand(page *Page, cmd *Command, pre bool) error {
 	processor, err := cmd.Processor(s)
 	if err != nil {
-		return err
+		return NewBuildError(page, cmd, err)
 	}
-	if (processor.Mode()&Pre != 0) != pre {
+	if (processor.Mode()&Pre != 0) != pre || !cmd.Applies(page) {
 		return nil
 	}
-	return processor.Process(page, cmd.Args())
+	args, err := cmd.PageArgs(page)
+	if err != nil {
+		return NewBuildError(page, cmd, err)
+	}
+	err = processor.Process(page, args)
+	if err != nil {
+		return NewBuildError(page, cmd, err)
+	}
+	return nil
 }
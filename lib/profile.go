@@ -0,0 +1,189 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ConfigOptions changes the way config is read.
+type ConfigOptions struct {
+	// name of `[profile NAME]' section to apply
+	Profile string
+	// KEY=VALUE constants, which override ones from config, paths are
+	// relative to current directory
+	Defines []string
+}
+
+// override is a constant which takes precedence over the one defined in
+// config, paths are relative to base
+type override struct {
+	name  string
+	value string
+	base  string
+	used  bool
+}
+
+// configLine is a line of config stored to be applied later
+type configLine struct {
+	file  string
+	line  int
+	base  string
+	level int
+	text  string
+}
+
+func (l configLine) error(err error) error {
+	return &ConfigError{l.file, l.line, err}
+}
+
+// override finds overriding constant, names are compared the same way
+// constants are looked up, so `url' overrides `URL'
+func (cfg *SiteConfig) override(name string) *override {
+	for _, o := range cfg.overrides {
+		if Capitalize(o.name) == Capitalize(name) {
+			return o
+		}
+	}
+	return nil
+}
+
+// ParseSection parses `[profile NAME]' line and returns name of the profile
+func (cfg *SiteConfig) ParseSection(line string) (string, error) {
+	bits := strings.Fields(line[1 : len(line)-1])
+	if len(bits) != 2 || bits[0] != "profile" {
+		return "", fmt.Errorf("unknown section '%s', only [profile NAME] is supported", line)
+	}
+	cfg.profiles[bits[1]] = true
+	return bits[1], nil
+}
+
+func defineOverrides(defines []string) ([]*override, error) {
+	overrides := make([]*override, 0, len(defines))
+	for _, define := range defines {
+		bits := TrimSplitN(define, "=", 2)
+		if len(bits) != 2 || bits[0] == "" {
+			return nil, fmt.Errorf("cannot parse '%s', KEY=VALUE expected", define)
+		}
+		overrides = append(overrides, &override{name: bits[0], value: bits[1]})
+	}
+	return overrides, nil
+}
+
+func isConstant(l configLine) bool {
+	return l.level == 0 && !IsRegexPattern(l.text) && strings.Contains(l.text, "=")
+}
+
+// profileOverrides returns constants defined in a profile
+func profileOverrides(lines []configLine) []*override {
+	overrides := make([]*override, 0)
+	for _, l := range lines {
+		if isConstant(l) {
+			bits := TrimSplitN(l.text, "=", 2)
+			overrides = append(overrides, &override{name: bits[0], value: bits[1], base: l.base})
+		}
+	}
+	return overrides
+}
+
+// applyOverrides defines overriding constants, which were not defined in
+// config
+func (cfg *SiteConfig) applyOverrides() error {
+	for _, o := range cfg.overrides {
+		if !o.used {
+			err := cfg.ParseVariable(o.base, o.name+" = "+o.value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", o.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyProfile changes rules according to a profile: new dependencies and
+// commands are added, commands prefixed with `- ' are removed
+func (cfg *SiteConfig) applyProfile() error {
+	var current []*Rule
+	// rules and commands are recorded as defined in the profile
+	defer func() { cfg.file, cfg.line = "", 0 }()
+	for _, l := range cfg.profile {
+		cfg.file, cfg.line = l.file, l.line
+		switch {
+		case isConstant(l):
+			continue
+
+		case l.level == 0 && strings.HasPrefix(l.text, "include "):
+			return l.error(errors.New("include is not supported in profiles"))
+
+		case l.level == 0 && strings.HasPrefix(l.text, "define "):
+			return l.error(errors.New("define is not supported in profiles"))
+
+		case l.level == 0:
+			bits := splitRule(l.text)
+			if len(bits) != 2 {
+				return l.error(fmt.Errorf("cannot parse rule, ':' not found in '%s'", l.text))
+			}
+
+			current = cfg.Rules[bits[0]]
+			if current == nil {
+				rule, err := cfg.ParseRule(l.text)
+				if err != nil {
+					return l.error(err)
+				}
+				current = []*Rule{rule}
+				continue
+			}
+
+			deps := NonEmptySplit(cfg.SubVars(bits[1]), " ")
+			for _, dep := range deps {
+				if !doublestar.ValidatePattern(strings.TrimPrefix(dep, "!")) {
+					return l.error(fmt.Errorf("invalid dependency pattern '%s'", dep))
+				}
+			}
+			for _, rule := range current {
+				rule.Deps = append(rule.Deps, deps...)
+			}
+
+		case l.level == 1:
+			if current == nil {
+				return l.error(errors.New("indent without rules"))
+			}
+			if !strings.HasPrefix(l.text, "- ") {
+				for _, rule := range current {
+					if err := rule.ParseCommand(cfg, l.text); err != nil {
+						return l.error(err)
+					}
+				}
+				continue
+			}
+
+			prefix := Command(cfg.SubVars(strings.TrimSpace(l.text[2:])))
+			removed := false
+			for _, rule := range current {
+				commands := make(CommandList, 0, len(rule.Commands))
+				lines := make([]configPos, 0, len(rule.lines))
+				for i, cmd := range rule.Commands {
+					if cmd.Matches(prefix) {
+						removed = true
+					} else {
+						commands = append(commands, cmd)
+						lines = append(lines, rule.lines[i])
+					}
+				}
+				rule.Commands, rule.lines = commands, lines
+			}
+			if !removed {
+				return l.error(fmt.Errorf("command '%s' not found", prefix))
+			}
+
+		default:
+			return l.error(fmt.Errorf("unhandled situation: %s", l.text))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestName is the name of a file in the output directory, which lists all
+// files gostatic has written there
+const ManifestName = ".gostatic-manifest"
+
+func (site *Site) manifestPath() string {
+	return filepath.Join(site.Output, ManifestName)
+}
+
+// outputs returns paths (relative to output directory) of files produced by
+// current pages; failed pages produce nothing, their path could be wrong
+func (site *Site) outputs() map[string]bool {
+	outputs := make(map[string]bool, len(site.Pages))
+	for _, page := range site.Pages {
+		if !site.Failed(page) {
+			outputs[filepath.ToSlash(filepath.Clean(page.Path))] = true
+		}
+	}
+	return outputs
+}
+
+// keepStale tells if stale outputs should be kept: either it was asked for, or
+// build has errors, and then last good outputs of failed pages are among them
+func (site *Site) keepStale() bool {
+	if site.NoPrune {
+		return true
+	}
+	site.mx.Lock()
+	defer site.mx.Unlock()
+	return len(site.Errors) > 0
+}
+
+// readManifest returns paths listed in the manifest, missing manifest is not
+// an error since there was no build yet
+func (site *Site) readManifest() ([]string, error) {
+	file, err := os.Open(site.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	paths := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		// never touch anything outside of output directory
+		if path == "" || filepath.IsAbs(path) ||
+			strings.HasPrefix(filepath.Clean(path), "..") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, scanner.Err()
+}
+
+func (site *Site) writeManifest(paths []string) error {
+	sort.Strings(paths)
+
+	err := os.MkdirAll(site.Output, 0755)
+	if err != nil {
+		return err
+	}
+
+	content := strings.Join(paths, "\n")
+	if len(paths) > 0 {
+		content += "\n"
+	}
+	return ioutil.WriteFile(site.manifestPath(), []byte(content), 0644)
+}
+
+// StaleOutputs returns files written by previous builds (according to the
+// manifest), which are not produced by any of current pages
+func (site *Site) StaleOutputs() ([]string, error) {
+	previous, err := site.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	current := site.outputs()
+	stale := make([]string, 0)
+	for _, path := range previous {
+		if current[path] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(site.Output, path)); err == nil {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// RemoveStale removes stale outputs (see StaleOutputs) along with directories
+// which become empty after that, and returns a list of removed files. Nothing
+// is removed if build has errors.
+func (site *Site) RemoveStale() ([]string, error) {
+	if site.keepStale() {
+		return nil, nil
+	}
+	stale, err := site.StaleOutputs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range stale {
+		debug("Removing stale %s\n", path)
+		fn := filepath.Join(site.Output, path)
+		if err := os.Remove(fn); err != nil {
+			return nil, err
+		}
+
+		// remove directories up to output root, until one is not empty
+		for dir := filepath.Dir(fn); dir != site.Output &&
+			strings.HasPrefix(dir, site.Output); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return stale, nil
+}
+
+// updateManifest records outputs of current pages in the manifest. If stale
+// outputs were not removed (see keepStale), they are kept in the manifest so
+// that later build can remove them.
+func (site *Site) updateManifest() error {
+	paths := make([]string, 0, len(site.Pages))
+	for path := range site.outputs() {
+		paths = append(paths, path)
+	}
+
+	if site.keepStale() {
+		stale, err := site.StaleOutputs()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, stale...)
+	}
+
+	return site.writeManifest(paths)
+}
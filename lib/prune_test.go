@@ -0,0 +1,171 @@
+package gostatic
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func pruneSite(t *testing.T, manifest string, files ...string) *Site {
+	dir := t.TempDir()
+	site := &Site{}
+	site.Output = filepath.Join(dir, "out")
+	for _, fn := range files {
+		path := filepath.Join(site.Output, fn)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if manifest != "" {
+		if err := ioutil.WriteFile(site.manifestPath(), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return site
+}
+
+func readTestManifest(t *testing.T, site *Site) string {
+	data, err := ioutil.ReadFile(site.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRemoveStaleMissingManifest(t *testing.T) {
+	site := pruneSite(t, "", "old.html")
+	site.Pages = PageSlice{&Page{Path: "new.html"}}
+
+	removed, err := site.RemoveStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected nothing to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(site.Output, "old.html")); err != nil {
+		t.Error("files not in manifest should be kept")
+	}
+}
+
+func TestRemoveStaleRenamed(t *testing.T) {
+	site := pruneSite(t, "blog/2020/old.html\nblog/keep.html\nindex.html\n",
+		"blog/2020/old.html", "blog/keep.html", "index.html", "new/post.html")
+	site.Pages = PageSlice{
+		&Page{Path: "index.html"},
+		&Page{Path: "blog/keep.html"},
+		&Page{Path: "new/post.html"},
+	}
+
+	removed, err := site.RemoveStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"blog/2020/old.html"}) {
+		t.Errorf("unexpected removed files %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(site.Output, "blog", "2020")); !os.IsNotExist(err) {
+		t.Error("expected empty directory to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(site.Output, "blog", "keep.html")); err != nil {
+		t.Error("expected non-empty directory to be kept")
+	}
+
+	if err := site.updateManifest(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "blog/keep.html\nindex.html\nnew/post.html\n"
+	if m := readTestManifest(t, site); m != expected {
+		t.Errorf("expected manifest %q, got %q", expected, m)
+	}
+}
+
+func TestReadManifestOutsideOutput(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "outside.html")
+	if err := ioutil.WriteFile(outside, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	site := pruneSite(t, "../outside.html\n"+outside+"\nsub/../../x\n\nstale.html\n",
+		"stale.html")
+
+	paths, err := site.readManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(paths, []string{"stale.html"}) {
+		t.Errorf("expected only paths inside output, got %v", paths)
+	}
+
+	removed, err := site.RemoveStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"stale.html"}) {
+		t.Errorf("unexpected removed files %v", removed)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("files outside of output should never be removed")
+	}
+}
+
+func TestUpdateManifestNoPrune(t *testing.T) {
+	site := pruneSite(t, "gone.html\nindex.html\nold.html\n",
+		"index.html", "old.html")
+	site.Pages = PageSlice{&Page{Path: "index.html"}}
+	site.NoPrune = true
+
+	if err := site.updateManifest(); err != nil {
+		t.Fatal(err)
+	}
+	// gone.html is not in output anymore, so there is nothing to remove
+	expected := "index.html\nold.html\n"
+	if m := readTestManifest(t, site); m != expected {
+		t.Errorf("expected manifest %q, got %q", expected, m)
+	}
+
+	site.NoPrune = false
+	removed, err := site.RemoveStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"old.html"}) {
+		t.Errorf("expected stale file to be removed by later build, got %v",
+			removed)
+	}
+}
+
+func TestRemoveStaleKeepGoing(t *testing.T) {
+	site := pruneSite(t, "blog/bad/index.html\nindex.html\nold.html\n",
+		"blog/bad/index.html", "index.html", "old.html")
+	site.failed = make(map[*Page]bool)
+	// rename of a failed page did not run, so its path is the source one
+	bad := &Page{Source: "blog/bad.md", Path: "blog/bad.md"}
+	site.Pages = PageSlice{&Page{Path: "index.html"}, bad}
+	site.KeepGoing = true
+	site.addError(bad, errors.New("broken date"))
+
+	removed, err := site.RemoveStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected nothing to be removed with errors, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(site.Output, "blog", "bad", "index.html")); err != nil {
+		t.Error("expected last good output of a failed page to be kept")
+	}
+
+	if err := site.updateManifest(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "blog/bad/index.html\nindex.html\nold.html\n"
+	if m := readTestManifest(t, site); m != expected {
+		t.Errorf("expected manifest %q, got %q", expected, m)
+	}
+}
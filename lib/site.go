@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -15,93 +17,243 @@ import (
 
 type Site struct {
 	ConfigPath string
+	ConfigOptions
 	SiteConfig
 	Template  *template.Template
 	ChangedAt time.Time
 	Pages     PageSlice
 
 	ForceRefresh bool
-
+	// Jobs is the number of pages processed in parallel, number of CPUs if
+	// not set
+	Jobs int
+	// KeepGoing makes build continue after errors, otherwise it stops at
+	// the first one
+	KeepGoing bool
+	// NoPrune keeps files produced by previous builds, which no page
+	// produces anymore, in the output directory
+	NoPrune bool
+	// UseHashes makes Page.Changed() compare content hashes stored in a
+	// build database rather than modification times
+	UseHashes bool
+
+	// template name -> file it's defined in, and modification times and
+	// hashes of those files
+	templateFiles  map[string]string
+	templateTimes  map[string]time.Time
+	templateHashes map[string]string
+
+	db          *BuildDB
+	fingerprint string
+
+	// dependencies discovered by previous build and fingerprint of pages
+	// collected from source, see discover.go
+	discovered       map[string]*DiscoveredDeps
+	pagesFingerprint string
+
+	// decides which files in source directory are part of a site, guarded
+	// by mx since file watcher uses it during rebuild
+	filter *sourceFilter
+
+	// page config defaults by directory, see defaults.go
+	defaults   map[string]*pageDefaults
+	defaultsMx sync.Mutex
+
+	// Errors collected since last Reconfig
+	Errors BuildErrors
+	failed map[*Page]bool
+
+	// guards modifications of Pages, Errors and failed
 	mx sync.Mutex
+	// guards state of pages being processed, see Page.Process
+	procMx sync.Mutex
 
 	Processors map[string]Processor
 }
 
-// make new instance of Site
+// make new instance of Site, call Reconfig to read config and pages
 func NewSite(configPath string, procs ProcessorMap) *Site {
 	site := &Site{
 		ConfigPath: configPath,
-		//SiteConfig: *config,
-		//Template:   template,
-		//ChangedAt:  changed,
-		//		Pages:      make(PageSlice, 0),
 		Processors: procs,
 	}
 
-	site.Reconfig()
-
 	return site
 }
 
-// read site config, templates and find all eligible pages
-func (site *Site) Reconfig() {
-	config, err := NewSiteConfig(site.ConfigPath)
+// read site config, templates and find all eligible pages; returned error
+// means that config or templates are broken, while errors in pages are
+// collected in site.Errors
+func (site *Site) Reconfig() error {
+	config, err := NewSiteConfig(site.ConfigPath, site.ConfigOptions)
 	if err != nil {
-		errhandle(fmt.Errorf("invalid config file '%s': %v", site.ConfigPath, err))
-		os.Exit(2) // ExitCodeInvalidConfig
+		return err
 	}
-	site.SiteConfig = *config
 
-	template := template.New("no-idea-what-to-pass-here").Funcs(TemplateFuncMap)
-	template, err = template.ParseFiles(site.SiteConfig.Templates...)
-	errhandle(err)
+	template := template.New("no-idea-what-to-pass-here").
+		Funcs(TemplateFuncMap).
+		Funcs(template.FuncMap{
+			"env": EnvFunc(strings.Fields(config.Other["Env_allow"])),
+		})
+	template, err = template.ParseFiles(config.Templates...)
+	if err != nil {
+		return err
+	}
+	for _, t := range template.Templates() {
+		BindPageQueries(t)
+	}
+	site.SiteConfig = *config
 
-	changed := site.SiteConfig.changedAt
-	for _, fn := range site.SiteConfig.Templates {
+	// pages depend on templates they use (see Page.TemplateFiles), so
+	// ChangedAt only tracks config itself
+	site.templateFiles, err = templateFiles(config.Templates)
+	if err != nil {
+		return err
+	}
+	site.templateTimes = make(map[string]time.Time)
+	for _, fn := range config.Templates {
 		stat, err := os.Stat(fn)
-		if err == nil && changed.Before(stat.ModTime()) {
-			changed = stat.ModTime()
+		if err == nil {
+			site.templateTimes[fn] = stat.ModTime()
+		}
+	}
+
+	site.db = nil
+	if site.UseHashes {
+		site.db, err = OpenBuildDB(filepath.Join(config.Output, DatabaseName))
+		if err != nil {
+			return err
+		}
+		site.fingerprint, err = configFingerprint(config)
+		if err != nil {
+			return err
+		}
+		site.templateHashes = make(map[string]string)
+		for _, fn := range config.Templates {
+			site.templateHashes[fn], err = hashFiles(fn)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	site.Template = template
-	site.ChangedAt = changed
+	site.ChangedAt = config.changedAt
 	site.Pages = make(PageSlice, 0)
+	site.Errors = nil
+	site.failed = make(map[*Page]bool)
+
+	ignore, err := ReadIgnore(config.Source,
+		strings.Fields(config.Other["Ignore"]))
+	if err != nil {
+		return err
+	}
+	site.mx.Lock()
+	site.filter = &sourceFilter{
+		source:   config.Source,
+		ignore:   ignore,
+		dotfiles: NewIgnore(strings.Fields(config.Other["Include_dotfiles"])),
+	}
+	site.mx.Unlock()
+	site.defaults = make(map[string]*pageDefaults)
 
 	site.Collect()
 	site.FindDeps()
+
+	site.pagesFingerprint = site.pageSet()
+	site.discovered, err = site.readDiscovered()
+	return err
+}
+
+// addError records an error which happened to a page (which can be nil)
+func (site *Site) addError(page *Page, err error) *BuildError {
+	be := NewBuildError(page, nil, err)
+	if site == nil {
+		errhandle(be)
+		return be
+	}
+
+	site.mx.Lock()
+	defer site.mx.Unlock()
+	site.Errors = append(site.Errors, be)
+	if page != nil {
+		site.failed[page] = true
+	}
+	return be
+}
+
+// err returns collected errors (sorted by source, since pages are processed
+// in parallel) as an error, or nil if there were none
+func (site *Site) err() error {
+	site.mx.Lock()
+	defer site.mx.Unlock()
+	if len(site.Errors) == 0 {
+		return nil
+	}
+	errs := append(BuildErrors(nil), site.Errors...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Source < errs[j].Source
+	})
+	return errs
+}
+
+// Failed tells if there was an error while building a page
+func (site *Site) Failed(page *Page) bool {
+	site.mx.Lock()
+	defer site.mx.Unlock()
+	return site.failed[page]
 }
 
 func (site *Site) AddPages(path string) {
-	for _, page := range NewPages(site, path) {
+	pages, err := NewPages(site, path)
+	if err != nil {
+		site.addError(nil, &BuildError{Source: path, Err: err})
+		return
+	}
+	for _, page := range pages {
 		if page.state != StateIgnored {
+			site.mx.Lock()
 			site.Pages = append(site.Pages, page)
+			site.mx.Unlock()
 		}
 	}
 }
 
-func (site *Site) Collect() {
-	errors := make(chan error, 10)
-
-	filepath.Walk(site.Source, site.collectFunc(errors))
+// AddPage adds a (usually virtual) page to the site unless there is already a
+// page with the same source, returns true if page was added
+func (site *Site) AddPage(page *Page) bool {
+	site.mx.Lock()
+	defer site.mx.Unlock()
 
-	select {
-	case err := <-errors:
-		errhandle(err)
-	default:
+	if site.Pages.BySource(page.Source) != nil {
+		return false
 	}
+	site.Pages = append(site.Pages, page)
+	return true
+}
 
+func (site *Site) Collect() {
+	filepath.Walk(site.Source, site.collectFunc())
 	site.Pages.Sort()
 }
 
-func (site *Site) collectFunc(errors chan<- error) filepath.WalkFunc {
+func (site *Site) collectFunc() filepath.WalkFunc {
 	return func(fn string, fi os.FileInfo, err error) error {
 		if err != nil {
-			errors <- err
+			site.addError(nil, &BuildError{Source: fn, Err: err})
 			return nil
 		}
 
-		if !fi.IsDir() && !strings.HasPrefix(filepath.Base(fn), ".") {
+		relpath, _ := filepath.Rel(site.Source, fn)
+		if site.filter.excluded(filepath.ToSlash(relpath), fi.IsDir()) {
+			// there is no need to look inside of ignored directories
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !fi.IsDir() && filepath.Base(fn) != DefaultsName {
 			site.AddPages(fn)
 		}
 
@@ -109,78 +261,289 @@ func (site *Site) collectFunc(errors chan<- error) filepath.WalkFunc {
 	}
 }
 
+// Skipped tells if change of a file should not trigger a rebuild, i.e. file
+// is not a part of a site
+func (site *Site) Skipped(fn string) bool {
+	site.mx.Lock()
+	filter := site.filter
+	site.mx.Unlock()
+
+	relpath, err := filepath.Rel(filter.source, fn)
+	relpath = filepath.ToSlash(relpath)
+	if err != nil || strings.HasPrefix(relpath, "../") {
+		// templates and other files outside of source
+		return strings.HasPrefix(filepath.Base(fn), ".")
+	}
+	if relpath == IgnoreName {
+		return false
+	}
+	isDir := false
+	if fi, err := os.Stat(fn); err == nil {
+		isDir = fi.IsDir()
+	}
+	return filter.excluded(relpath, isDir)
+}
+
 func (site *Site) FindDeps() {
 	for _, page := range site.Pages {
 		page.findDeps()
 	}
 }
 
+func (site *Site) jobs() int {
+	if site.Jobs > 0 {
+		return site.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// run calls fn for every page in pages using a pool of site.jobs() workers and
+// returns first error encountered, after which no new pages are started. If
+// ordered is true, page is started only when all of its dependencies (which
+// are in pages as well) are done; dependency cycles are broken arbitrarily.
+func (site *Site) run(pages PageSlice, ordered bool, fn func(*Page) error) error {
+	queue := pages
+	waits := make(map[*Page]PageSlice)
+	if ordered {
+		queue, waits = schedule(pages)
+	}
+
+	done := make(map[*Page]chan struct{}, len(queue))
+	for _, page := range queue {
+		done[page] = make(chan struct{})
+	}
+
+	var (
+		mx     sync.Mutex
+		first  error
+		wg     sync.WaitGroup
+		tasks  = make(chan *Page)
+		failed = func() bool {
+			mx.Lock()
+			defer mx.Unlock()
+			return first != nil
+		}
+	)
+
+	for i := 0; i < site.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range tasks {
+				for _, dep := range waits[page] {
+					<-done[dep]
+				}
+				if !failed() {
+					if err := fn(page); err != nil {
+						mx.Lock()
+						if first == nil {
+							first = err
+						}
+						mx.Unlock()
+					}
+				}
+				close(done[page])
+			}
+		}()
+	}
+
+	for _, page := range queue {
+		tasks <- page
+	}
+	close(tasks)
+	wg.Wait()
+
+	return first
+}
+
+// schedule sorts pages so that dependencies go before pages depending on
+// them, and returns, for every page, a list of dependencies it has to wait for
+func schedule(pages PageSlice) (PageSlice, map[*Page]PageSlice) {
+	const (
+		unseen = iota
+		visiting
+		visited
+	)
+
+	member := make(map[*Page]bool, len(pages))
+	for _, page := range pages {
+		member[page] = true
+	}
+
+	order := make(PageSlice, 0, len(pages))
+	waits := make(map[*Page]PageSlice)
+	state := make(map[*Page]int, len(pages))
+
+	var visit func(page *Page)
+	visit = func(page *Page) {
+		state[page] = visiting
+		for _, dep := range page.Deps {
+			if !member[dep] {
+				continue
+			}
+			switch state[dep] {
+			case unseen:
+				visit(dep)
+				waits[page] = append(waits[page], dep)
+			case visited:
+				waits[page] = append(waits[page], dep)
+			}
+			// visiting means there is a cycle, which is broken here
+		}
+		state[page] = visited
+		order = append(order, page)
+	}
+
+	for _, page := range pages {
+		if state[page] == unseen {
+			visit(page)
+		}
+	}
+
+	return order, waits
+}
+
+// Process processes changed pages and returns how many were processed
+// successfully. Error is returned if there were any, in which case (unless
+// site.KeepGoing is set) processing stops at the first one.
 func (site *Site) Process() (int, error) {
-	processed := 0
+	// state of pages is computed before going parallel, since Changed()
+	// recurses into dependencies
+	changed := make(PageSlice, 0)
 	for _, page := range site.Pages {
 		if page.Changed() {
-			debug("Processing page %s\n", page.Source)
-			_, err := page.Process()
-			if err != nil {
-				return processed, err
-			}
-			processed++
+			changed = append(changed, page)
 		}
 	}
-	return processed, nil
+
+	var processed int
+	var mx sync.Mutex
+	site.run(changed, true, func(page *Page) error {
+		debug("Processing page %s\n", page.Source)
+		if _, err := page.Process(); err != nil {
+			return site.fail(page, err)
+		}
+		mx.Lock()
+		processed++
+		mx.Unlock()
+		return nil
+	})
+	return processed, site.err()
 }
 
 func (site *Site) ProcessAll() error {
-	for _, page := range site.Pages {
-		_, err := page.Process()
-		if err != nil {
-			return err
+	site.run(site.Pages, true, func(page *Page) error {
+		if _, err := page.Process(); err != nil {
+			return site.fail(page, err)
 		}
+		return nil
+	})
+	return site.err()
+}
+
+// fail records an error which happened to a page and returns it if build
+// should stop because of it
+func (site *Site) fail(page *Page, err error) error {
+	be := site.addError(page, err)
+	if site.KeepGoing {
+		return nil
 	}
-	return nil
+	return be
 }
 
-func (site *Site) Summary() {
+func (site *Site) Summary() error {
+	if err := site.err(); err != nil && !site.KeepGoing {
+		return err
+	}
+
 	err := site.ProcessAll()
-	errhandle(err)
+	if err != nil && !site.KeepGoing {
+		return err
+	}
 
 	out("Total pages to render: %d\n", len(site.Pages))
 
 	for _, page := range site.Pages {
 		// do not output static files in summary mode
-		if page.Rule == nil {
+		if page.Rule == nil || site.Failed(page) {
 			continue
 		}
 
-		out("%s - %s: %d chars; %s\n",
-			page.Path, page.Title, len(page.Content()), page.Rule)
+		out("%s - %s: %d chars; rule '%s'\n",
+			page.Path, page.Title, len(page.Content()), page.Pattern)
 		out("------------")
 		_, err := page.WriteTo(os.Stdout)
-		errhandle(err)
+		if err != nil {
+			site.addError(page, err)
+		}
 		out("------------\n")
 	}
+	return site.err()
 }
 
-func (site *Site) Render() {
+// Render processes and writes all changed pages to the output directory.
+// Returned error is BuildErrors with everything that went wrong since last
+// Reconfig.
+func (site *Site) Render() error {
+	if err := site.err(); err != nil && !site.KeepGoing {
+		return err
+	}
+
 	processed, err := site.Process()
-	errhandle(err)
+	if err != nil && !site.KeepGoing {
+		return err
+	}
 	out("Rendering %d changed pages of %d total\n", processed, len(site.Pages))
 
+	// stale files are removed before rendering, since they could be in the
+	// way of new ones (i.e. `name' file becoming `name/index.html')
+	if !site.NoPrune {
+		removed, err := site.RemoveStale()
+		if err != nil {
+			site.addError(nil, fmt.Errorf("unable to remove stale outputs: %v", err))
+		} else if len(removed) > 0 {
+			out("Removed %d stale files\n", len(removed))
+		}
+	}
+
+	changed := make(PageSlice, 0)
 	for _, page := range site.Pages {
-		if !page.Changed() {
-			continue
+		if page.Changed() && !site.Failed(page) {
+			changed = append(changed, page)
 		}
+	}
 
+	site.run(changed, false, func(page *Page) error {
 		debug("Rendering %s -> %s\n", page.Source, page.OutputPath())
 
 		err := os.MkdirAll(filepath.Dir(page.OutputPath()), 0755)
-		errhandle(err)
-
-		_, err = page.Render()
+		if err == nil {
+			_, err = page.Render()
+		}
 		if err != nil {
-			errhandle(fmt.Errorf("Unable to render page '%s': %v", page.Source, err))
+			return site.fail(page, fmt.Errorf("unable to render: %v", err))
+		}
+		if site.db != nil {
+			if err := site.db.markWritten(page); err != nil {
+				return site.fail(page, err)
+			}
+		}
+		return nil
+	})
+
+	site.warnUndeclared()
+	if err := site.saveDiscovered(); err != nil {
+		site.addError(nil, fmt.Errorf("unable to write dependencies: %v", err))
+	}
+	if err := site.updateManifest(); err != nil {
+		site.addError(nil, fmt.Errorf("unable to write manifest: %v", err))
+	}
+	if site.db != nil {
+		if err := site.db.Save(site); err != nil {
+			site.addError(nil, fmt.Errorf("unable to write build database: %v", err))
 		}
 	}
+	return site.err()
 }
 
 func (site *Site) Lookup(path string) *Page {
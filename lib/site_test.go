@@ -0,0 +1,166 @@
+package gostatic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduleOrdersDeps(t *testing.T) {
+	a := &Page{Source: "a"}
+	b := &Page{Source: "b"}
+	c := &Page{Source: "c"}
+	// a depends on b, b depends on c, c depends on a (cycle)
+	a.Deps = PageSlice{b}
+	b.Deps = PageSlice{c}
+	c.Deps = PageSlice{a}
+
+	order, waits := schedule(PageSlice{a, b, c})
+
+	if len(order) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(order))
+	}
+	pos := make(map[*Page]int)
+	for i, page := range order {
+		pos[page] = i
+	}
+	for page, deps := range waits {
+		for _, dep := range deps {
+			if pos[dep] > pos[page] {
+				t.Errorf("%s waits for %s, which is scheduled later",
+					page.Source, dep.Source)
+			}
+		}
+	}
+}
+
+func TestRunRespectsDeps(t *testing.T) {
+	site := &Site{Jobs: 4}
+	pages := make(PageSlice, 0)
+	for i := 0; i < 20; i++ {
+		page := &Page{Site: site, Source: string(rune('a' + i))}
+		if i > 0 {
+			page.Deps = PageSlice{pages[i-1]}
+		}
+		pages = append(pages, page)
+	}
+
+	var mx sync.Mutex
+	seen := make(map[*Page]bool)
+	err := site.run(pages, true, func(page *Page) error {
+		mx.Lock()
+		defer mx.Unlock()
+		for _, dep := range page.Deps {
+			if !seen[dep] {
+				t.Errorf("%s started before its dependency %s",
+					page.Source, dep.Source)
+			}
+		}
+		seen[page] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(pages) {
+		t.Errorf("expected %d pages to be run, got %d", len(pages), len(seen))
+	}
+}
+
+type versionProcessor struct{}
+
+func (p versionProcessor) Process(page *Page, args []string) error {
+	for _, arg := range args {
+		if _, err := Versionize(page, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p versionProcessor) Description() string { return "" }
+func (p versionProcessor) Mode() int           { return 0 }
+
+func TestProcessVersionCycles(t *testing.T) {
+	for _, jobs := range []int{1, 4} {
+		site := &Site{
+			Jobs:         jobs,
+			ForceRefresh: true,
+			Processors:   ProcessorMap{"version": versionProcessor{}},
+		}
+		// a uses itself, b and c use each other
+		for _, cmd := range []string{"version a.html", "version c.html", "version b.html"} {
+			name := string(rune('a'+len(site.Pages))) + ".html"
+			page := &Page{Site: site, Source: name, Path: name,
+				Rule: &Rule{Commands: CommandList{Command(cmd)}}}
+			page.SetContent(name)
+			site.Pages = append(site.Pages, page)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := site.Process()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("jobs %d: %v", jobs, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("jobs %d: processing pages which use each other hangs", jobs)
+		}
+	}
+}
+
+type failProcessor struct{}
+
+func (p failProcessor) Process(page *Page, args []string) error {
+	return errors.New("broken")
+}
+
+func (p failProcessor) Description() string { return "" }
+func (p failProcessor) Mode() int           { return 0 }
+
+func TestProcessKeepGoing(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		site := &Site{
+			Jobs:         1,
+			KeepGoing:    keepGoing,
+			ForceRefresh: true,
+			Processors:   ProcessorMap{"fail": failProcessor{}},
+			failed:       make(map[*Page]bool),
+		}
+		for _, name := range []string{"c.md", "b.md", "a.md"} {
+			page := &Page{Site: site, Source: name, Path: name, Pattern: "*.md",
+				Rule: &Rule{Commands: CommandList{"fail"}}}
+			site.Pages = append(site.Pages, page)
+		}
+
+		_, err := site.Process()
+		errs, ok := err.(BuildErrors)
+		if !ok {
+			t.Fatalf("keep going %v: expected BuildErrors, got %#v", keepGoing, err)
+		}
+		if !keepGoing {
+			if len(errs) != 1 || errs[0].Source != "c.md" {
+				t.Errorf("expected build to stop at first error, got %v", errs)
+			}
+			continue
+		}
+
+		expected := "build failed with 3 errors:\n" +
+			"  a.md (rule '*.md', command 'fail'): broken\n" +
+			"  b.md (rule '*.md', command 'fail'): broken\n" +
+			"  c.md (rule '*.md', command 'fail'): broken"
+		if err.Error() != expected {
+			t.Errorf("expected errors of all pages, got %q", err.Error())
+		}
+		for _, page := range site.Pages {
+			if !site.Failed(page) {
+				t.Errorf("expected %s to be failed", page.Source)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gostatic
 
 import (
+	"errors"
 	"fmt"
 	"hash/adler32"
 	"io"
@@ -11,12 +12,19 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
 )
 
-var inventory = map[string]interface{}{}
+var (
+	inventory   = map[string]interface{}{}
+	inventoryMx sync.Mutex
+)
 
 func HasChanged(name string, value interface{}) bool {
+	inventoryMx.Lock()
+	defer inventoryMx.Unlock()
+
 	changed := true
 
 	if inventory[name] == value {
@@ -59,13 +67,18 @@ func Hash(value string) string {
 }
 
 func Versionize(current *Page, value string) (string, error) {
+	return versionize(current, current, value)
+}
+
+// versionize is Versionize called while reader is being processed
+func versionize(reader, current *Page, value string) (string, error) {
 	page := current.Site.Pages.ByPath(value)
 	if page == nil {
-		errhandle(fmt.Errorf(
+		return "", fmt.Errorf(
 			"trying to versionize page which does not exist: %s, current: %s",
-			value, current.Path))
+			value, current.Path)
 	}
-	_, err := page.Process()
+	_, err := page.processFor(reader)
 	if err != nil {
 		return "", err
 	}
@@ -302,6 +315,32 @@ func AbcSort(pages PageSlice) PageSlice {
 	return pages
 }
 
+// EnvFunc returns `env' template function, which reads environment variables
+// with names matching one of allowed globs, and returns fallback (if given)
+// when variable is not set or empty.
+func EnvFunc(allowed []string) func(name string, fallback ...string) (string, error) {
+	return func(name string, fallback ...string) (string, error) {
+		permitted := false
+		for _, pattern := range allowed {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return "", fmt.Errorf(
+				"environment variable '%s' is not allowed, add it to ENV_ALLOW in config",
+				name)
+		}
+
+		value := os.Getenv(name)
+		if value == "" && len(fallback) > 0 {
+			return fallback[0], nil
+		}
+		return value, nil
+	}
+}
+
 // TemplateFuncMap contains the mapping of function names and their corresponding
 // Go functions, to be used within templates.
 var TemplateFuncMap = template.FuncMap{
@@ -335,4 +374,15 @@ var TemplateFuncMap = template.FuncMap{
 	"base":           Base,
 	"absurl":         Absurl,
 	"abcsort":        AbcSort,
+	"env":            EnvFunc(nil), // see Site.Reconfig
+	"pages":          noPages,      // see Page.Template
+	"readPage":       readNothing,  // see Page.Template
+}
+
+func noPages() (PageSlice, error) {
+	return nil, errors.New("pages can only be queried while processing a page")
+}
+
+func readNothing(value interface{}) interface{} {
+	return value
 }
@@ -1,6 +1,7 @@
 package gostatic
 
 import (
+	"os"
 	"testing"
 )
 
@@ -25,3 +26,25 @@ func TestExcerpt(t *testing.T) {
 		}
 	}
 }
+
+func TestEnv(t *testing.T) {
+	os.Setenv("GOSTATIC_TEST_SHA", "abc")
+	os.Setenv("GOSTATIC_SECRET", "x")
+	env := EnvFunc([]string{"GOSTATIC_TEST_*"})
+
+	if value, err := env("GOSTATIC_TEST_SHA"); err != nil || value != "abc" {
+		t.Errorf("Expected \"abc\", got \"%s\" (%v)", value, err)
+	}
+	if value, err := env("GOSTATIC_TEST_MISSING", "dev"); err != nil || value != "dev" {
+		t.Errorf("Expected fallback \"dev\", got \"%s\" (%v)", value, err)
+	}
+	if _, err := env("GOSTATIC_SECRET"); err == nil {
+		t.Errorf("Expected error for variable not in allowlist")
+	}
+
+	cfg := &SiteConfig{}
+	value := cfg.SubVars("$(env:GOSTATIC_TEST_SHA)-$(env:GOSTATIC_TEST_MISSING:-none)")
+	if value != "abc-none" {
+		t.Errorf("Expected \"abc-none\", got \"%s\"", value)
+	}
+}
@@ -0,0 +1,140 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateUser is implemented by processors, which execute templates, so that
+// pages are rebuilt only when templates they use change.
+type TemplateUser interface {
+	// Templates returns names of templates processor executes for a page
+	Templates(page *Page, args []string) []string
+}
+
+// templateFiles maps names of all templates to files they are defined in,
+// later files override earlier ones, same as with template.ParseFiles
+func templateFiles(paths []string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		t, err := template.New(name).Funcs(TemplateFuncMap).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, tmpl := range t.Templates() {
+			files[tmpl.Name()] = path
+		}
+	}
+	return files, nil
+}
+
+// walkTemplateRefs calls fn with name of every template invoked in node
+func walkTemplateRefs(node parse.Node, fn func(name string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplateRefs(child, fn)
+		}
+	case *parse.IfNode:
+		walkTemplateRefs(n.List, fn)
+		walkTemplateRefs(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplateRefs(n.List, fn)
+		walkTemplateRefs(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplateRefs(n.List, fn)
+		walkTemplateRefs(n.ElseList, fn)
+	case *parse.TemplateNode:
+		fn(n.Name)
+	}
+}
+
+// TemplateRefs returns names of site templates referenced from text, which is
+// itself a template (like one executed by `inner-template')
+func (site *Site) TemplateRefs(text string) []string {
+	t, err := site.Template.Clone()
+	if err != nil {
+		return nil
+	}
+	t, err = t.New("ad-hoc").Parse(text)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	walkTemplateRefs(t.Tree.Root, func(name string) {
+		names = append(names, name)
+	})
+	return names
+}
+
+// templateClosure returns names of given templates along with all the
+// templates they invoke, recursively
+func (site *Site) templateClosure(names []string) map[string]bool {
+	seen := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		t := site.Template.Lookup(name)
+		if t == nil || t.Tree == nil {
+			return
+		}
+		walkTemplateRefs(t.Tree.Root, visit)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+	return seen
+}
+
+// TemplateFiles returns template files page uses while being processed
+func (page *Page) TemplateFiles() []string {
+	if page.Rule == nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, cmd := range page.Rule.Commands {
+		processor, err := cmd.Processor(page.Site)
+		if err != nil || !cmd.Applies(page) {
+			continue
+		}
+		if user, ok := processor.(TemplateUser); ok {
+			args, err := cmd.PageArgs(page)
+			if err == nil {
+				names = append(names, user.Templates(page, args)...)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+	for name := range page.Site.templateClosure(names) {
+		file, ok := page.Site.templateFiles[name]
+		if ok && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,137 @@
+// (c) 2012 Alexander Solovyov
+// under terms of ISC license
+
+package gostatic
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// SplitCommand splits command into words the way shell does: words are
+// separated by whitespace, everything inside of single quotes is taken
+// literally, inside of double quotes backslash escapes only `"' and `\', and
+// outside of quotes backslash escapes any character.
+func SplitCommand(s string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	switch {
+	case escaped:
+		return nil, errors.New("unfinished escape at the end of command")
+	case quote != 0:
+		return nil, errors.New("unterminated quote in command")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// QuoteArg quotes a word, so that SplitCommand reads it back as is
+func QuoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\\'\"#") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// StripComment removes comment from a config line: `#' starts a comment when
+// it's at the beginning of a word and is not quoted or escaped
+func StripComment(line string) string {
+	quote := rune(0)
+	escaped := false
+	wordStart := true
+
+	for i, c := range line {
+		literal := escaped
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && wordStart:
+			return line[:i]
+		}
+		wordStart = !literal && quote == 0 && unicode.IsSpace(c)
+	}
+	return line
+}
+
+// StripValueComment removes comment from a config line which is not split
+// into words (constants and rule headers): `#' starts a comment when it's at
+// the beginning of a word, and `\#' is replaced with `#', same as in commands.
+// Quotes are not special, since they are a part of value (`Bob's site').
+func StripValueComment(line string) string {
+	var b strings.Builder
+	wordStart := true
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '#':
+			c = '#'
+			i++
+		case c == '#' && wordStart:
+			return b.String()
+		}
+		b.WriteByte(c)
+		wordStart = line[i] == ' ' || line[i] == '\t'
+	}
+	return b.String()
+}
+
+// IsContinued tells if line ends with unescaped backslash, which means that
+// it continues on the next line
+func IsContinued(line string) bool {
+	line = strings.TrimRight(line, " \t\r")
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	return n%2 == 1
+}
+
+// joinContinued joins line ending with a backslash with the next one
+func joinContinued(line, next string) string {
+	line = strings.TrimRight(line, " \t\r")
+	return strings.TrimRight(line[:len(line)-1], " \t") + " " +
+		strings.TrimSpace(next)
+}
@@ -0,0 +1,129 @@
+package gostatic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	var testTable = []struct {
+		input    string
+		expected []string
+	}{
+		{"markdown", []string{"markdown"}},
+		{"ext  .html", []string{"ext", ".html"}},
+		{`sh -c "a | b"`, []string{"sh", "-c", "a | b"}},
+		{`rename 'my page.html'`, []string{"rename", "my page.html"}},
+		{`rename my\ page.html`, []string{"rename", "my page.html"}},
+		{`echo "say \"hi\" \n"`, []string{"echo", `say "hi" \n`}},
+		{`echo 'a\b' "" x`, []string{"echo", `a\b`, "", "x"}},
+		{`echo a"b c"d`, []string{"echo", "ab cd"}},
+		{`echo \#1`, []string{"echo", "#1"}},
+		{"", []string{}},
+	}
+
+	for _, s := range testTable {
+		out, err := SplitCommand(s.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", s.input, err)
+		}
+		if !reflect.DeepEqual(out, s.expected) {
+			t.Errorf("%s: expected %q, got %q", s.input, s.expected, out)
+		}
+	}
+
+	for _, input := range []string{`echo "a`, `echo 'a`, `echo a\`} {
+		if _, err := SplitCommand(input); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	var testTable = []struct {
+		input    string
+		expected string
+	}{
+		{"markdown # comment", "markdown "},
+		{"# comment", ""},
+		{"URL = http://example.com/#top", "URL = http://example.com/#top"},
+		{`echo \# not a comment`, `echo \# not a comment`},
+		{`echo "# not" '# a' comment`, `echo "# not" '# a' comment`},
+		{`echo "a" #comment`, `echo "a" `},
+	}
+
+	for _, s := range testTable {
+		if out := StripComment(s.input); out != s.expected {
+			t.Errorf("%s: expected %q, got %q", s.input, s.expected, out)
+		}
+	}
+}
+
+func TestStripValueComment(t *testing.T) {
+	var testTable = []struct {
+		input    string
+		expected string
+	}{
+		{"TITLE = Bob's site  # comment", "TITLE = Bob's site  "},
+		{`TITLE = "quoted # comment`, `TITLE = "quoted `},
+		{`TITLE = C\# notes # comment`, "TITLE = C# notes "},
+		{"URL = http://example.com/#top", "URL = http://example.com/#top"},
+		{"# comment", ""},
+	}
+
+	for _, s := range testTable {
+		if out := StripValueComment(s.input); out != s.expected {
+			t.Errorf("%s: expected %q, got %q", s.input, s.expected, out)
+		}
+	}
+}
+
+// Commands and constants share the rules for `#' and `\#', while quotes are
+// only special in commands, since constants are not split into words.
+func TestCommentRules(t *testing.T) {
+	var testTable = []struct {
+		input   string
+		value   string
+		command []string
+	}{
+		{`a \#1 # comment`, "a #1 ", []string{"a", "#1"}},
+		{`a b#1 #comment`, "a b#1 ", []string{"a", "b#1"}},
+		{`a "#1" # comment`, `a "#1" `, []string{"a", "#1"}},
+		{`a " #1"`, `a " `, []string{"a", " #1"}},
+		{`a Bob's # comment`, "a Bob's ", nil},
+	}
+
+	for _, s := range testTable {
+		if out := StripValueComment(s.input); out != s.value {
+			t.Errorf("%s: expected value %q, got %q", s.input, s.value, out)
+		}
+		out, err := SplitCommand(StripComment(s.input))
+		if s.command == nil {
+			if err == nil {
+				t.Errorf("%s: expected unterminated quote in command", s.input)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(out, s.command) {
+			t.Errorf("%s: expected command %q, got %q (%v)", s.input, s.command, out, err)
+		}
+	}
+}
+
+func TestIsContinued(t *testing.T) {
+	var testTable = []struct {
+		input    string
+		expected bool
+	}{
+		{`markdown \`, true},
+		{"markdown \\ \t", true},
+		{`markdown \\`, false},
+		{`markdown`, false},
+	}
+
+	for _, s := range testTable {
+		if out := IsContinued(s.input); out != s.expected {
+			t.Errorf("%q: expected %v, got %v", s.input, s.expected, out)
+		}
+	}
+}
@@ -22,14 +22,6 @@ func errhandle(err error) {
 	fmt.Printf("Error: %s\n", err)
 }
 
-func errexit(err error) {
-	if err == nil {
-		return
-	}
-	fmt.Printf("Fatal error: %s\n", err)
-	os.Exit(1)
-}
-
 func out(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 }
@@ -1,6 +1,7 @@
 package processors
 
 import (
+	"errors"
 	"regexp"
 
 	gostatic "github.com/piranha/gostatic/lib"
@@ -41,10 +42,20 @@ func ProcessConfig(page *gostatic.Page, args []string) error {
 		// page starts with a separator but then no second separator? This means
 		// no configuration is present.
 		if len(parts) != 3 {
-			page.PageHeader = *gostatic.NewPageHeader()
-			return nil
+			return page.SetHeader(gostatic.NewPageHeader())
+		}
+		header, err := gostatic.ParseHeader(parts[1])
+		if err != nil {
+			// account for the opening separator
+			var le *gostatic.LineError
+			if errors.As(err, &le) {
+				le.Line++
+			}
+			return err
+		}
+		if err := page.SetHeader(header); err != nil {
+			return err
 		}
-		page.PageHeader = *gostatic.ParseHeader(parts[1])
 		page.SetContent(parts[2])
 	} else {
 		// this branch parses old gostatic-style frontmatter, i.e.
@@ -54,10 +65,15 @@ func ProcessConfig(page *gostatic.Page, args []string) error {
 		parts := oldSeparator.Split(page.Content(), 2)
 		if len(parts) != 2 {
 			// no separator to split content? No configuration is present then.
-			page.PageHeader = *gostatic.NewPageHeader()
-			return nil
+			return page.SetHeader(gostatic.NewPageHeader())
+		}
+		header, err := gostatic.ParseHeader(parts[0])
+		if err != nil {
+			return err
+		}
+		if err := page.SetHeader(header); err != nil {
+			return err
 		}
-		page.PageHeader = *gostatic.ParseHeader(parts[0])
 		page.SetContent(parts[1])
 	}
 	return nil
@@ -24,4 +24,8 @@ var DefaultProcessors = gostatic.ProcessorMap{
 	"ignorefuture":           NewIgnoreFutureProcessor(),
 	"jekyllify":              NewJekyllifyProcessor(),
 	"yaml":                   NewYamlProcessor(),
+	"toml":                   NewTomlProcessor(),
+	"json":                   NewJsonProcessor(),
+	"frontmatter":            NewFrontmatterProcessor(),
+	"schema":                 NewSchemaProcessor(),
 }
@@ -0,0 +1,41 @@
+package processors
+
+import (
+	"strings"
+
+	gostatic "github.com/piranha/gostatic/lib"
+)
+
+type FrontmatterProcessor struct {
+}
+
+func NewFrontmatterProcessor() *FrontmatterProcessor {
+	return &FrontmatterProcessor{}
+}
+
+func (p *FrontmatterProcessor) Process(page *gostatic.Page, args []string) error {
+	return ProcessFrontmatter(page, args)
+}
+
+func (p *FrontmatterProcessor) Description() string {
+	return "read config from content in format detected by its start: " +
+		"'---' is yaml, '+++' is toml, json object is json, anything else is config"
+}
+
+func (p *FrontmatterProcessor) Mode() int {
+	return gostatic.Pre
+}
+
+func ProcessFrontmatter(page *gostatic.Page, args []string) error {
+	content := page.Content()
+	switch {
+	// '----' is a separator of config processor
+	case strings.HasPrefix(content, "---") && !strings.HasPrefix(content, "----"):
+		return ProcessYaml(page, args)
+	case strings.HasPrefix(content, "+++"):
+		return ProcessToml(page, args)
+	case hasJsonObject(content):
+		return ProcessJson(page, args)
+	}
+	return ProcessConfig(page, args)
+}
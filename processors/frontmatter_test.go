@@ -0,0 +1,51 @@
+package processors
+
+import (
+	gostatic "github.com/piranha/gostatic/lib"
+	"testing"
+)
+
+// Tests if ProcessFrontmatter reads config in every supported format.
+func TestProcessFrontmatter(t *testing.T) {
+	sources := map[string]string{
+		"yaml":   "---\ntitle: Test Page\nweight: 10\n---\nContent\n",
+		"toml":   "+++\ntitle = \"Test Page\"\nweight = 10\n+++\nContent\n",
+		"json":   "{\"title\": \"Test Page\", \"weight\": 10}\nContent\n",
+		"config": "title: Test Page\nweight: 10\n----\nContent\n",
+	}
+
+	for format, source := range sources {
+		page := &gostatic.Page{}
+		page.SetContent(source)
+
+		if err := ProcessFrontmatter(page, nil); err != nil {
+			t.Errorf("%s: unexpected error %v", format, err)
+			continue
+		}
+		if page.Title != "Test Page" {
+			t.Errorf("%s: expected 'Test Page', got '%s'", format, page.Title)
+		}
+		if page.Other["Weight"] != "10" {
+			t.Errorf("%s: expected weight '10', got '%s'", format, page.Other["Weight"])
+		}
+		if page.Content() != "Content\n" {
+			t.Errorf("%s: expected 'Content\\n', got '%s'", format, page.Content())
+		}
+	}
+
+	// template code is not json
+	source := "{{ .Title }}\nContent\n"
+	for name, process := range map[string]func(*gostatic.Page, []string) error{
+		"frontmatter": ProcessFrontmatter,
+		"json":        ProcessJson,
+	} {
+		page := &gostatic.Page{}
+		page.SetContent(source)
+		if err := process(page, nil); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if page.Content() != source {
+			t.Errorf("%s: expected content to be intact, got '%s'", name, page.Content())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package processors
+
+import (
+	"encoding/json"
+	"strings"
+
+	gostatic "github.com/piranha/gostatic/lib"
+)
+
+type JsonProcessor struct {
+}
+
+func NewJsonProcessor() *JsonProcessor {
+	return &JsonProcessor{}
+}
+
+func (p *JsonProcessor) Process(page *gostatic.Page, args []string) error {
+	return ProcessJson(page, args)
+}
+
+func (p *JsonProcessor) Description() string {
+	return "read config from content using json format (object at the start of content)"
+}
+
+func (p *JsonProcessor) Mode() int {
+	return gostatic.Pre
+}
+
+// hasJsonObject tells if content starts with a json object, and not with
+// something like template code (`{{ .Title }}')
+func hasJsonObject(content string) bool {
+	if !strings.HasPrefix(content, "{") {
+		return false
+	}
+	rest := strings.TrimLeft(content[1:], " \t\r\n")
+	return strings.HasPrefix(rest, "\"") || strings.HasPrefix(rest, "}")
+}
+
+func ProcessJson(page *gostatic.Page, args []string) error {
+	content := page.Content()
+	if !hasJsonObject(content) {
+		// no configuration, well then...
+		return page.SetHeader(gostatic.NewPageHeader())
+	}
+
+	// object ends where decoder stops reading it
+	var raw json.RawMessage
+	dec := json.NewDecoder(strings.NewReader(content))
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	header, err := gostatic.ParseJsonHeader(string(raw))
+	if err != nil {
+		return err
+	}
+	if err := page.SetHeader(header); err != nil {
+		return err
+	}
+
+	rest := content[dec.InputOffset():]
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, "\r"), "\n")
+	page.SetContent(rest)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	gostatic "github.com/piranha/gostatic/lib"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -53,8 +54,16 @@ var Paginated = map[string]gostatic.PageSlice{}
 
 var Paginators = map[string]*Paginator{}
 
+// paginationMx guards Paginated, Paginators and sorted
+var paginationMx sync.RWMutex
+
+// sorted marks path patterns which have their Paginated list sorted already
+var sorted = map[string]bool{}
+
 func CurrentPaginator(current *gostatic.Page) *Paginator {
 	// from processors.go
+	paginationMx.RLock()
+	defer paginationMx.RUnlock()
 	return Paginators[current.Source]
 }
 
@@ -68,6 +77,8 @@ func NewPaginator() *Paginator {
 
 func (pagi Paginator) Prev() *Paginator {
 	src := strings.Replace(pagi.PathPattern, "*", strconv.Itoa(pagi.Number-1), 1)
+	paginationMx.RLock()
+	defer paginationMx.RUnlock()
 	if prev, ok := Paginators[src]; ok {
 		return prev
 	}
@@ -76,6 +87,8 @@ func (pagi Paginator) Prev() *Paginator {
 
 func (pagi Paginator) Next() *Paginator {
 	src := strings.Replace(pagi.PathPattern, "*", strconv.Itoa(pagi.Number+1), 1)
+	paginationMx.RLock()
+	defer paginationMx.RUnlock()
 	if next, ok := Paginators[src]; ok {
 		return next
 	}
@@ -92,21 +105,26 @@ func ProcessPaginate(page *gostatic.Page, args []string) error {
 	}
 	pathPattern := args[1]
 
+	paginationMx.Lock()
 	if pages, ok := Paginated[pathPattern]; ok {
 		Paginated[pathPattern] = append(pages, page)
 	} else {
 		Paginated[pathPattern] = gostatic.PageSlice{page}
 	}
+	sorted[pathPattern] = false
+	total := len(Paginated[pathPattern])
+	paginationMx.Unlock()
 
 	site := page.Site
 
 	// page number, 1-based
-	n := 1 + ((len(Paginated[pathPattern]) - 1) / length)
+	n := 1 + ((total - 1) / length)
 	listpath := strings.Replace(pathPattern, "*", strconv.Itoa(n), 1)
 	listpage := site.Pages.BySource(listpath)
 
 	//todo catch this
 	if listpage != nil {
+		listpage.AddOrigin(page)
 		return nil
 	}
 
@@ -133,22 +151,27 @@ func ProcessPaginate(page *gostatic.Page, args []string) error {
 		PageHeader: gostatic.PageHeader{Title: strconv.Itoa(n)},
 		Site:       site,
 		Pattern:    pattern,
+		Captures:   rule.Captures(listpath),
 		Rule:       rule,
 		Source:     listpath,
 		Path:       listpath,
 		ModTime:    time.Unix(int64(n), 0),
 	}
 	listpage.SetWasRead(true)
-	page.Site.Pages = append(page.Site.Pages, listpage)
-	listpage.Peek()
+	listpage.AddOrigin(page)
+	if !site.AddPage(listpage) {
+		return nil
+	}
 
+	paginationMx.Lock()
 	Paginators[listpath] = &Paginator{
 		Number:      n,
 		PathPattern: pathPattern,
 		Page:        listpage,
 		Pages:       make(gostatic.PageSlice, 0),
 	}
-	return nil
+	paginationMx.Unlock()
+	return listpage.Peek()
 }
 
 func MinInt(a, b int) int {
@@ -164,13 +187,16 @@ func ProcessPaginateCollectPages(page *gostatic.Page, args []string) error {
 		return err
 	}
 
+	paginationMx.Lock()
+	defer paginationMx.Unlock()
+
 	pagi := Paginators[page.Source]
 	paginated := Paginated[pagi.PathPattern]
-	// NOTE: this hack for calling .Sort only once relies on the fact that
-	// site.Pages are sorted by .ModTime (if they don't have .Date), and
-	// .ModTime depends on a pagi.Number.
-	if pagi.Number == 1 {
+	// paginators of the same pattern are processed in parallel, so whoever is
+	// first sorts the list for everyone
+	if !sorted[pagi.PathPattern] {
 		paginated.Sort()
+		sorted[pagi.PathPattern] = true
 	}
 
 	pagi.Pages = paginated[(pagi.Number-1)*length : MinInt(len(paginated), pagi.Number*length)]
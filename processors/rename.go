@@ -21,7 +21,7 @@ func (p *RenameProcessor) Process(page *gostatic.Page, args []string) error {
 
 func (p *RenameProcessor) Description() string {
 	return "rename resulting file (argument - pattern for renaming, " +
-		"relative to current file location)"
+		"relative to current file location or to site root if starts with /)"
 }
 
 func (p *RenameProcessor) Mode() int {
@@ -33,7 +33,14 @@ func ProcessRename(page *gostatic.Page, args []string) error {
 	}
 	dest := args[0]
 
-	if strings.Contains(dest, "*") {
+	// path starting with a slash is relative to site root
+	if strings.HasPrefix(dest, "/") {
+		page.Path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(dest)), "/")
+		return nil
+	}
+
+	// regular expression rules use $1 instead of *
+	if strings.Contains(dest, "*") && !gostatic.IsRegexPattern(page.Pattern) {
 		if !strings.Contains(page.Pattern, "*") {
 			return fmt.Errorf(
 				"'rename' rule cannot rename '%s' to '%s'",
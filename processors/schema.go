@@ -0,0 +1,225 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gostatic "github.com/piranha/gostatic/lib"
+)
+
+type SchemaProcessor struct {
+}
+
+func NewSchemaProcessor() *SchemaProcessor {
+	return &SchemaProcessor{}
+}
+
+func (p *SchemaProcessor) Process(page *gostatic.Page, args []string) error {
+	return ProcessSchema(page, args)
+}
+
+func (p *SchemaProcessor) Description() string {
+	return "check page config (arguments - 'key:check,check', checks are " +
+		"required, int, number, bool, date, min=N, max=N, in=a|b; " +
+		"'strict' rejects keys not listed)"
+}
+
+func (p *SchemaProcessor) Mode() int {
+	return gostatic.Pre
+}
+
+type fieldCheck func(value string) error
+
+var schemaChecks = map[string]func(arg string) (fieldCheck, error){
+	"int": func(arg string) (fieldCheck, error) {
+		return func(value string) error {
+			if _, err := strconv.Atoi(value); err != nil {
+				return errors.New("must be an integer")
+			}
+			return nil
+		}, nil
+	},
+	"number": func(arg string) (fieldCheck, error) {
+		return func(value string) error {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return errors.New("must be a number")
+			}
+			return nil
+		}, nil
+	},
+	"bool": func(arg string) (fieldCheck, error) {
+		return func(value string) error {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return errors.New("must be true or false")
+			}
+			return nil
+		}, nil
+	},
+	"date": func(arg string) (fieldCheck, error) {
+		return func(value string) error {
+			for _, format := range gostatic.DATEFORMATS {
+				if _, err := time.Parse(format, value); err == nil {
+					return nil
+				}
+			}
+			return errors.New("must be a date")
+		}, nil
+	},
+	"min": func(arg string) (fieldCheck, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("min needs a number, got '%s'", arg)
+		}
+		return func(value string) error {
+			if utf8.RuneCountInString(value) < n {
+				return fmt.Errorf("must be at least %d characters long", n)
+			}
+			return nil
+		}, nil
+	},
+	"max": func(arg string) (fieldCheck, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("max needs a number, got '%s'", arg)
+		}
+		return func(value string) error {
+			if utf8.RuneCountInString(value) > n {
+				return fmt.Errorf("must be at most %d characters long", n)
+			}
+			return nil
+		}, nil
+	},
+	"in": func(arg string) (fieldCheck, error) {
+		allowed := strings.Split(arg, "|")
+		return func(value string) error {
+			for _, a := range allowed {
+				if a == value {
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+		}, nil
+	},
+}
+
+type fieldSchema struct {
+	key      string
+	required bool
+	checks   []fieldCheck
+}
+
+// parseSchema parses arguments of schema processor
+func parseSchema(args []string) (fields []fieldSchema, strict bool, err error) {
+	for _, arg := range args {
+		if arg == "strict" {
+			strict = true
+			continue
+		}
+		bits := strings.SplitN(arg, ":", 2)
+		// keys can be written like in templates, `Other.Category'
+		field := fieldSchema{key: strings.TrimPrefix(bits[0], "Other.")}
+		if field.key == "" {
+			return nil, false, fmt.Errorf("cannot parse '%s', 'key:check,check' expected", arg)
+		}
+		if len(bits) == 2 {
+			for _, check := range strings.Split(bits[1], ",") {
+				name, checkArg := check, ""
+				if i := strings.Index(check, "="); i != -1 {
+					name, checkArg = check[:i], check[i+1:]
+				}
+				if name == "required" {
+					field.required = true
+					continue
+				}
+				makeCheck := schemaChecks[name]
+				if makeCheck == nil {
+					return nil, false, fmt.Errorf("unknown check '%s' for '%s'", name, field.key)
+				}
+				fn, err := makeCheck(checkArg)
+				if err != nil {
+					return nil, false, err
+				}
+				field.checks = append(field.checks, fn)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, strict, nil
+}
+
+// headerValue returns value of a page config key, if it was set
+func headerValue(page *gostatic.Page, key string) (string, bool) {
+	found := false
+	for k := range page.Params {
+		if strings.EqualFold(k, key) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	switch gostatic.Capitalize(key) {
+	case "Title":
+		return page.Title, true
+	case "Date":
+		return page.Date.Format(time.RFC3339), true
+	case "Tags":
+		return strings.Join(page.Tags, ","), true
+	case "Hide":
+		return strconv.FormatBool(page.Hide), true
+	}
+	return page.Other[gostatic.Capitalize(key)], true
+}
+
+func ProcessSchema(page *gostatic.Page, args []string) error {
+	fields, strict, err := parseSchema(args)
+	if err != nil {
+		return err
+	}
+
+	problems := make([]string, 0)
+	for _, field := range fields {
+		value, ok := headerValue(page, field.key)
+		if !ok {
+			if field.required {
+				problems = append(problems, fmt.Sprintf("%s is required", field.key))
+			}
+			continue
+		}
+		for _, check := range field.checks {
+			if err := check(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %v", field.key, err))
+			}
+		}
+	}
+
+	if strict {
+		unknown := make([]string, 0)
+		for k := range page.Params {
+			known := false
+			for _, field := range fields {
+				known = known || strings.EqualFold(k, field.key)
+			}
+			if !known {
+				unknown = append(unknown, k)
+			}
+		}
+		sort.Strings(unknown)
+		for _, k := range unknown {
+			problems = append(problems, fmt.Sprintf("%s is not in schema", k))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("page config does not match schema: %s",
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package processors
+
+import (
+	gostatic "github.com/piranha/gostatic/lib"
+	"strings"
+	"testing"
+)
+
+// Tests if ProcessSchema reports every problem with page config.
+func TestProcessSchema(t *testing.T) {
+	page := &gostatic.Page{}
+	page.SetContent("title: A rather long title\ncategory: misc\nweight: heavy\nauthr: me\n----\n")
+	if err := ProcessConfig(page, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"title:required,max=10", "date:required,date",
+		"category:in=news|blog", "weight:int"}
+	err := ProcessSchema(page, args)
+	if err == nil {
+		t.Fatal("expected schema error")
+	}
+	for _, problem := range []string{
+		"title must be at most 10 characters long",
+		"date is required",
+		"category must be one of news, blog",
+		"weight must be an integer",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected '%s' in '%v'", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "authr") {
+		t.Errorf("unexpected unknown key error without strict: %v", err)
+	}
+
+	err = ProcessSchema(page, []string{"title", "category", "weight", "strict"})
+	if err == nil || !strings.Contains(err.Error(), "authr is not in schema") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+
+	if err := ProcessSchema(page, []string{"title:required,long"}); err == nil {
+		t.Error("expected error for unknown check")
+	}
+}
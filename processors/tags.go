@@ -43,7 +43,9 @@ func ProcessTags(page *gostatic.Page, args []string) error {
 	for _, tag := range page.Tags {
 		tagpath := strings.Replace(pathPattern, "*", tag, 1)
 
-		if site.Pages.BySource(tagpath) == nil {
+		if tagpage := site.Pages.BySource(tagpath); tagpage != nil {
+			tagpage.AddOrigin(page)
+		} else {
 			pattern, rules := site.Rules.MatchedRules(tagpath)
 			if rules == nil {
 				return fmt.Errorf("Tag path '%s' does not match any rule", tagpath)
@@ -56,6 +58,7 @@ func ProcessTags(page *gostatic.Page, args []string) error {
 				PageHeader: gostatic.PageHeader{Title: tag},
 				Site:       site,
 				Pattern:    pattern,
+				Captures:   rules[0].Captures(tagpath),
 				Rule:       rules[0],
 				Source:     tagpath,
 				Path:       tagpath,
@@ -64,8 +67,12 @@ func ProcessTags(page *gostatic.Page, args []string) error {
 				ModTime: time.Unix(0, 0),
 			}
 			tagpage.SetWasRead(true)
-			page.Site.Pages = append(page.Site.Pages, tagpage)
-			tagpage.Peek()
+			tagpage.AddOrigin(page)
+			if site.AddPage(tagpage) {
+				if err := tagpage.Peek(); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
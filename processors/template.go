@@ -35,50 +35,63 @@ func (p *TemplateProcessor) Mode() int {
 	return 0
 }
 
-func ProcessTemplate(page *gostatic.Page, args []string) error {
+func (p *TemplateProcessor) Templates(page *gostatic.Page, args []string) []string {
+	if p.inner {
+		return page.Site.TemplateRefs(page.Raw())
+	}
+	if len(args) < 1 {
+		return nil
+	}
+	return args[:1]
+}
+
+func ProcessTemplate(page *gostatic.Page, args []string) (err error) {
 	if len(args) < 1 {
 		return errors.New("'template' rule needs an argument")
 	}
 	pagetype := args[0]
-	//todo catch thiss
 	defer func() {
-		if err := recover(); err != nil {
-			//return err //errors.New(fmt.Sprintf("%s: %s", page.Source, err))
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 
+	t, err := page.Template()
+	if err != nil {
+		return err
+	}
+
 	var buffer bytes.Buffer
-	err := page.Site.Template.ExecuteTemplate(&buffer, pagetype, page)
+	err = t.ExecuteTemplate(&buffer, pagetype, page)
 	if err != nil {
-		return errors.New(fmt.Sprintf("%s: %s", page.Source, err))
+		return err
 	}
 
 	page.SetContent(buffer.String())
 	return nil
 }
 
-func ProcessInnerTemplate(page *gostatic.Page, args []string) error {
-	//todo catch
+func ProcessInnerTemplate(page *gostatic.Page, args []string) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Printf("%s: %s\n", page.Source, err)
-			//return fmt.Sprintf("%s: %s", page.Source, err)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 
-	t, err := page.Site.Template.Clone()
+	t, err := page.Template()
 	if err != nil {
 		return err
 	}
 	t, err = t.New("ad-hoc").Parse(page.Content())
 	if err != nil {
-		return fmt.Errorf("Page %s: %s", page.Source, err)
+		return err
 	}
+	gostatic.BindPageQueries(t)
 
 	var buffer bytes.Buffer
 	err = t.ExecuteTemplate(&buffer, "ad-hoc", page)
 	if err != nil {
-		return fmt.Errorf("Page %s: %s", page.Source, err)
+		return err
 	}
 
 	page.SetContent(buffer.String())
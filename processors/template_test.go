@@ -0,0 +1,89 @@
+package processors
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gostatic "github.com/piranha/gostatic/lib"
+)
+
+// Tests that a page is changed when a template it reaches through nested
+// {{ template }} calls or its inner-template content changes, and only then.
+func TestTemplateChanges(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config": "TEMPLATES = base.tmpl nav.tmpl footer.tmpl extra.tmpl inner.tmpl\n" +
+			"SOURCE = src\nOUTPUT = out\n\n" +
+			"*.md:\n\ttemplate page\n\n" +
+			"*.html:\n\tinner-template\n",
+		"base.tmpl": `{{ define "page" }}{{ template "nav" }}{{ .Content }}{{ end }}` +
+			`{{ define "orphan" }}{{ template "extra" }}{{ end }}`,
+		"nav.tmpl":    `{{ define "nav" }}nav {{ template "footer" }}{{ end }}`,
+		"footer.tmpl": `{{ define "footer" }}footer{{ end }}`,
+		"extra.tmpl":  `{{ define "extra" }}extra{{ end }}`,
+		"inner.tmpl":  `{{ define "inner" }}inner{{ end }}`,
+		"src/a.md":    "a",
+		"src/b.html":  `{{ template "inner" }}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(dir, "config")
+
+	site := gostatic.NewSite(config, DefaultProcessors)
+	if err := site.Reconfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := site.Render(); err != nil {
+		t.Fatal(err)
+	}
+
+	var testTable = []struct {
+		template string
+		changed  []string
+	}{
+		{"footer.tmpl", []string{"a.md"}},
+		{"extra.tmpl", nil},
+		{"inner.tmpl", []string{"b.html"}},
+	}
+	for i, s := range testTable {
+		// later than outputs and changes of previous cases
+		future := time.Now().Add(time.Duration(i+1) * time.Hour)
+		err := os.Chtimes(filepath.Join(dir, s.template), future, future)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		site := gostatic.NewSite(config, DefaultProcessors)
+		if err := site.Reconfig(); err != nil {
+			t.Fatal(err)
+		}
+		changed := make(map[string]bool)
+		for _, source := range s.changed {
+			changed[source] = true
+		}
+		for _, page := range site.Pages {
+			if page.Changed() != changed[page.Source] {
+				t.Errorf("%s: expected %s changed to be %v, reason %q",
+					s.template, page.Source, changed[page.Source],
+					page.ChangeReason())
+			}
+		}
+
+		// bring outputs up to date for the next case
+		if err := site.Render(); err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range site.Pages {
+			later := future.Add(time.Minute)
+			os.Chtimes(page.OutputPath(), later, later)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package processors
+
+import (
+	"regexp"
+
+	gostatic "github.com/piranha/gostatic/lib"
+)
+
+var tomlSeparator = regexp.MustCompile(`(?sm)\A\+\+\+\r?\n(.*?)^\+\+\+\r?\n(.*)`)
+
+type TomlProcessor struct {
+}
+
+func NewTomlProcessor() *TomlProcessor {
+	return &TomlProcessor{}
+}
+
+func (p *TomlProcessor) Process(page *gostatic.Page, args []string) error {
+	return ProcessToml(page, args)
+}
+
+func (p *TomlProcessor) Description() string {
+	return "read config from content using toml format (separated by '+++\\n')"
+}
+
+func (p *TomlProcessor) Mode() int {
+	return gostatic.Pre
+}
+
+func ProcessToml(page *gostatic.Page, args []string) error {
+	parts := tomlSeparator.FindStringSubmatch(page.Content())
+
+	if len(parts) != 3 {
+		// no configuration, well then...
+		return page.SetHeader(gostatic.NewPageHeader())
+	}
+
+	header, err := gostatic.ParseTomlHeader(parts[1])
+	if err != nil {
+		return err
+	}
+	if err := page.SetHeader(header); err != nil {
+		return err
+	}
+	page.SetContent(parts[2])
+	return nil
+}
@@ -34,11 +34,16 @@ func ProcessYaml(page *gostatic.Page, args []string) error {
 
 	if len(parts) != 3 {
 		// no configuration, well then...
-		page.PageHeader = *gostatic.NewPageHeader()
-		return nil
+		return page.SetHeader(gostatic.NewPageHeader())
 	}
 
-	page.PageHeader = *gostatic.ParseYamlHeader(parts[1])
+	header, err := gostatic.ParseYamlHeader(parts[1])
+	if err != nil {
+		return err
+	}
+	if err := page.SetHeader(header); err != nil {
+		return err
+	}
 	page.SetContent(parts[2])
 	return nil
 }